@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+
+	middlewareapi "oidc/pkg/apis/middleware"
+	sessionsapi "oidc/pkg/apis/sessions"
+
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+const (
+	adminSessionsPath = "/admin/sessions"
+	adminSessionPath  = "/admin/sessions/{id}"
+)
+
+// buildAdminRoutes registers the session administration API. Callers
+// without the admin bearer token need a session in an admin group.
+func (p *OAuthProxy) buildAdminRoutes(s *mux.Router) {
+	chain := alice.New(p.requireAdmin)
+
+	s.Path(adminSessionsPath).Methods(http.MethodGet).Handler(chain.ThenFunc(p.AdminListSessions))
+	s.Path(adminSessionsPath).Methods(http.MethodDelete).Handler(chain.ThenFunc(p.AdminRevokeUserSessions))
+	s.Path(adminSessionPath).Methods(http.MethodDelete).Handler(chain.ThenFunc(p.AdminRevokeSession))
+}
+
+// requireAdmin only passes requests from callers that present the admin
+// bearer token or whose session belongs to one of the admin groups. The
+// admin token is checked before the session is loaded, so it is never sent
+// to the provider's introspection endpoint.
+func (p *OAuthProxy) requireAdmin(next http.Handler) http.Handler {
+	requireAdminSession := p.sessionChain.ThenFunc(func(rw http.ResponseWriter, req *http.Request) {
+		session := middlewareapi.GetRequestScope(req).Session
+		switch {
+		case p.isAdminSession(session):
+			next.ServeHTTP(rw, req)
+		case session == nil && req.Header.Get("Authorization") == "":
+			writeOAuthError(rw, http.StatusUnauthorized, "unauthorized", "authentication required")
+		default:
+			logger.PrintAuthf(sessionEmail(session), req, logger.AuthFailure, "Denied access to the session administration API")
+			writeOAuthError(rw, http.StatusForbidden, "forbidden", "admin access required")
+		}
+	})
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if p.isAdminToken(req) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		requireAdminSession.ServeHTTP(rw, req)
+	})
+}
+
+// isAdminToken checks the request's bearer token against the admin token
+func (p *OAuthProxy) isAdminToken(req *http.Request) bool {
+	if p.adminOptions.BearerToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(p.adminOptions.BearerToken)) == 1
+}
+
+// isAdminSession checks whether the session belongs to an admin group
+func (p *OAuthProxy) isAdminSession(session *sessionsapi.SessionState) bool {
+	if session == nil {
+		return false
+	}
+	for _, group := range session.Groups {
+		if _, ok := p.adminGroups[group]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminListSessions lists the active sessions of the user given by the
+// `user` query parameter, matched by subject or email
+func (p *OAuthProxy) AdminListSessions(rw http.ResponseWriter, req *http.Request) {
+	user := req.URL.Query().Get("user")
+	if user == "" {
+		writeOAuthError(rw, http.StatusBadRequest, "invalid_request", "missing user")
+		return
+	}
+
+	infos, err := p.sessionIndex().ListByUser(req.Context(), user)
+	if err != nil {
+		logger.Errorf("Error listing sessions for %s: %v", user, err)
+		writeOAuthError(rw, http.StatusInternalServerError, "server_error", "unable to list sessions")
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"sessions": infos,
+	})
+}
+
+// AdminRevokeUserSessions revokes every active session of the user given by
+// the `user` query parameter
+func (p *OAuthProxy) AdminRevokeUserSessions(rw http.ResponseWriter, req *http.Request) {
+	user := req.URL.Query().Get("user")
+	if user == "" {
+		writeOAuthError(rw, http.StatusBadRequest, "invalid_request", "missing user")
+		return
+	}
+
+	infos, err := p.sessionIndex().ListByUser(req.Context(), user)
+	if err != nil {
+		logger.Errorf("Error listing sessions for %s: %v", user, err)
+		writeOAuthError(rw, http.StatusInternalServerError, "server_error", "unable to list sessions")
+		return
+	}
+
+	revoked := 0
+	for _, info := range infos {
+		if err := p.adminRevoke(req.Context(), info.ID); err != nil {
+			logger.Errorf("Error revoking session %s: %v", info.ID, err)
+			continue
+		}
+		revoked++
+	}
+
+	logger.PrintAuthf(sessionEmail(middlewareapi.GetRequestScope(req).Session), req, logger.AuthSuccess, "Admin revoked %d session(s) of %s", revoked, user)
+	writeJSON(rw, http.StatusOK, map[string]int{
+		"revoked": revoked,
+	})
+}
+
+// AdminRevokeSession revokes a single session by the ID from its listing
+func (p *OAuthProxy) AdminRevokeSession(rw http.ResponseWriter, req *http.Request) {
+	id, err := url.PathUnescape(mux.Vars(req)["id"])
+	if err != nil || id == "" {
+		writeOAuthError(rw, http.StatusBadRequest, "invalid_request", "invalid session id")
+		return
+	}
+
+	if err := p.adminRevoke(req.Context(), id); err != nil {
+		logger.Errorf("Error revoking session %s: %v", id, err)
+		writeOAuthError(rw, http.StatusNotFound, "not_found", "session not found")
+		return
+	}
+
+	logger.PrintAuthf(sessionEmail(middlewareapi.GetRequestScope(req).Session), req, logger.AuthSuccess, "Admin revoked session %s", id)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// adminRevoke removes the session from the store and revokes its tokens at
+// the provider
+func (p *OAuthProxy) adminRevoke(ctx context.Context, id string) error {
+	index := p.sessionIndex()
+	session, err := index.LoadByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := index.ClearByID(ctx, id); err != nil {
+		return err
+	}
+	p.revokeSession(session)
+	return nil
+}
+
+// sessionIndex returns the session store as a SessionIndex. NewOAuthProxy
+// ensures the store supports it whenever the admin API is enabled.
+func (p *OAuthProxy) sessionIndex() sessionsapi.SessionIndex {
+	return p.sessionStore.(sessionsapi.SessionIndex)
+}
+
+// sessionEmail returns the session's email for logging, if there is a session
+func sessionEmail(session *sessionsapi.SessionState) string {
+	if session == nil {
+		return ""
+	}
+	return session.Email
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"oidc/pkg/apis/options"
+	sessionsapi "oidc/pkg/apis/sessions"
+	"oidc/pkg/metrics"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// authzCalloutRequest is the document POSTed to the authorization service
+type authzCalloutRequest struct {
+	User     string                 `json:"user"`
+	Email    string                 `json:"email,omitempty"`
+	Groups   []string               `json:"groups"`
+	Claims   map[string]interface{} `json:"claims,omitempty"`
+	Method   string                 `json:"method"`
+	Path     string                 `json:"path"`
+	ClientIP string                 `json:"client_ip"`
+}
+
+// authzDecision is the authorization service's answer. Headers are passed
+// to the upstream with allowed requests.
+type authzDecision struct {
+	Allow   bool              `json:"allow"`
+	Reason  string            `json:"reason,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	expires time.Time
+}
+
+// authzCallout asks an external service whether authenticated requests are
+// allowed, caching its decisions by the document sent
+type authzCallout struct {
+	url       string
+	timeout   time.Duration
+	cacheTTL  time.Duration
+	cacheSize int
+	failOpen  bool
+	// headers are the canonical names of the headers the service may set
+	headers []string
+
+	mu        sync.Mutex
+	decisions map[string]*list.Element
+	// order holds the cached decisions as *authzCacheEntry, oldest first.
+	// They all live for cacheTTL, so it is also the order they expire in.
+	order *list.List
+}
+
+type authzCacheEntry struct {
+	key      string
+	decision authzDecision
+}
+
+// newAuthzCallout returns nil when no authorization service is configured.
+// Expired decisions are swept every cache TTL.
+func newAuthzCallout(opts options.AuthzCalloutOptions) *authzCallout {
+	if opts.URL == "" {
+		return nil
+	}
+	c := &authzCallout{
+		url:       opts.URL,
+		timeout:   opts.Timeout,
+		cacheTTL:  opts.CacheTTL,
+		cacheSize: opts.CacheSize,
+		failOpen:  opts.FailOpen,
+		decisions: make(map[string]*list.Element),
+		order:     list.New(),
+	}
+	for _, name := range opts.Headers {
+		c.headers = append(c.headers, http.CanonicalHeaderKey(name))
+	}
+	if c.cacheTTL > 0 {
+		go c.sweep(time.NewTicker(c.cacheTTL))
+	}
+	return c
+}
+
+// authzCalloutRequest describes the session and request to the authorization
+// service
+func (p *OAuthProxy) authzCalloutRequest(req *http.Request, session *sessionsapi.SessionState) authzCalloutRequest {
+	in := p.policyInput(req, session)
+	return authzCalloutRequest{
+		User:     in.User,
+		Email:    in.Email,
+		Groups:   in.Groups,
+		Claims:   in.Claims,
+		Method:   in.Method,
+		Path:     in.Path,
+		ClientIP: in.ClientIP,
+	}
+}
+
+// authorize returns the service's decision for the document. When the
+// service fails, the request is allowed or denied as configured.
+func (c *authzCallout) authorize(ctx context.Context, doc authzCalloutRequest) authzDecision {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return c.failure(fmt.Errorf("error marshalling request: %v", err))
+	}
+	sum := sha256.Sum256(body)
+	key := hex.EncodeToString(sum[:])
+
+	if decision, ok := c.cached(key); ok {
+		return decision
+	}
+
+	decision, err := c.call(ctx, body)
+	if err != nil {
+		return c.failure(err)
+	}
+	if decision.Allow {
+		metrics.AuthzCallouts.Add("allow", 1)
+	} else {
+		metrics.AuthzCallouts.Add("deny", 1)
+	}
+	c.store(key, decision)
+	return decision
+}
+
+// call POSTs the document to the service. A 200 response carries the
+// decision, a 401 or 403 denies the request.
+func (c *authzCallout) call(ctx context.Context, body []byte) (authzDecision, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return authzDecision{}, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", applicationJSON)
+	req.Header.Set("Accept", applicationJSON)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return authzDecision{}, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return authzDecision{}, fmt.Errorf("error reading response: %v", err)
+	}
+
+	var decision authzDecision
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.Unmarshal(respBody, &decision); err != nil {
+			return authzDecision{}, fmt.Errorf("error parsing response: %v", err)
+		}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// The body may explain the denial, but a deny never depends on it
+		_ = json.Unmarshal(respBody, &decision)
+		decision.Allow = false
+		decision.Headers = nil
+	default:
+		return authzDecision{}, fmt.Errorf("unexpected status %d - %s", resp.StatusCode, respBody)
+	}
+	if decision.Reason == "" && !decision.Allow {
+		decision.Reason = "denied by authorization service"
+	}
+	return decision, nil
+}
+
+// failure is the decision when the service couldn't be asked
+func (c *authzCallout) failure(err error) authzDecision {
+	metrics.AuthzCallouts.Add("error", 1)
+	logger.Errorf("Error calling authorization service: %v", err)
+	if c.failOpen {
+		return authzDecision{Allow: true, Reason: "authorization service failed, failing open"}
+	}
+	return authzDecision{Reason: fmt.Sprintf("authorization service failed: %v", err)}
+}
+
+func (c *authzCallout) cached(key string) (authzDecision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.decisions[key]
+	if !ok {
+		return authzDecision{}, false
+	}
+	entry := elem.Value.(*authzCacheEntry)
+	if time.Now().After(entry.decision.expires) {
+		c.remove(elem)
+		return authzDecision{}, false
+	}
+	return entry.decision, true
+}
+
+// store caches the decision for cacheTTL. When the cache is full the oldest
+// decisions make room for it.
+func (c *authzCallout) store(key string, decision authzDecision) {
+	if c.cacheTTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	decision.expires = now.Add(c.cacheTTL)
+	if elem, ok := c.decisions[key]; ok {
+		elem.Value.(*authzCacheEntry).decision = decision
+		c.order.MoveToBack(elem)
+	} else {
+		c.decisions[key] = c.order.PushBack(&authzCacheEntry{key: key, decision: decision})
+	}
+	for c.order.Len() > c.cacheSize {
+		c.remove(c.order.Front())
+	}
+	c.removeExpired(now)
+}
+
+// sweep removes the expired decisions on every tick, so the cache shrinks
+// when requests stop
+func (c *authzCallout) sweep(ticker *time.Ticker) {
+	for now := range ticker.C {
+		c.mu.Lock()
+		c.removeExpired(now)
+		c.mu.Unlock()
+	}
+}
+
+// removeExpired removes the decisions expired at now, which are the oldest
+func (c *authzCallout) removeExpired(now time.Time) {
+	for elem := c.order.Front(); elem != nil; elem = c.order.Front() {
+		if !now.After(elem.Value.(*authzCacheEntry).decision.expires) {
+			return
+		}
+		c.remove(elem)
+	}
+}
+
+func (c *authzCallout) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.decisions, elem.Value.(*authzCacheEntry).key)
+}
+
+// checkAuthzCallout asks the authorization service about the request. When
+// it is allowed, the configured headers the service returned are set for the
+// upstream.
+// It returns the reason the request was denied, or an empty string.
+func (p *OAuthProxy) checkAuthzCallout(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) string {
+	if p.authzCallout == nil {
+		return ""
+	}
+	decision := p.authzCallout.authorize(req.Context(), p.authzCalloutRequest(req, session))
+	if !decision.Allow {
+		return decision.Reason
+	}
+	for name, value := range decision.Headers {
+		if !p.authzCallout.allowsHeader(name) {
+			logger.Printf("Warning: ignoring header %q from the authorization service, it isn't in authz_callout_headers", name)
+			continue
+		}
+		rw.Header().Set(name, value)
+	}
+	return ""
+}
+
+func (c *authzCallout) allowsHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, header := range c.headers {
+		if header == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"oidc/pkg/apis/options"
+	sessionsapi "oidc/pkg/apis/sessions"
+)
+
+// testAuthzService stands in for the authorization service. It allows the
+// users in allowed, returning headers for them, and denies the others.
+type testAuthzService struct {
+	*httptest.Server
+	calls int32
+	delay time.Duration
+}
+
+func newTestAuthzService(t *testing.T, allowed map[string]map[string]string) *testAuthzService {
+	t.Helper()
+
+	s := &testAuthzService{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&s.calls, 1)
+		time.Sleep(s.delay)
+
+		var doc authzCalloutRequest
+		if err := json.NewDecoder(req.Body).Decode(&doc); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		headers, ok := allowed[doc.User]
+		if !ok {
+			writeJSON(rw, http.StatusForbidden, map[string]string{"reason": doc.User + " is not entitled"})
+			return
+		}
+		writeJSON(rw, http.StatusOK, authzDecision{Allow: true, Headers: headers})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testAuthzService) callCount() int {
+	return int(atomic.LoadInt32(&s.calls))
+}
+
+func newTestAuthzCallout(url string, opts options.AuthzCalloutOptions) *authzCallout {
+	opts.URL = url
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second
+	}
+	if opts.CacheSize == 0 {
+		opts.CacheSize = 100
+	}
+	return newAuthzCallout(opts)
+}
+
+func TestAuthzCalloutDecisions(t *testing.T) {
+	service := newTestAuthzService(t, map[string]map[string]string{
+		"alice": {"X-Tenant": "blue"},
+	})
+	c := newTestAuthzCallout(service.URL, options.AuthzCalloutOptions{})
+
+	allowed := c.authorize(context.Background(), authzCalloutRequest{User: "alice", Method: http.MethodGet, Path: "/"})
+	if !allowed.Allow || allowed.Headers["X-Tenant"] != "blue" {
+		t.Errorf("allowed user: got %+v", allowed)
+	}
+
+	denied := c.authorize(context.Background(), authzCalloutRequest{User: "bob", Method: http.MethodGet, Path: "/"})
+	if denied.Allow || denied.Reason != "bob is not entitled" || denied.Headers != nil {
+		t.Errorf("denied user: got %+v", denied)
+	}
+}
+
+func TestAuthzCalloutCache(t *testing.T) {
+	service := newTestAuthzService(t, map[string]map[string]string{"alice": nil, "bob": nil, "carol": nil})
+	c := newTestAuthzCallout(service.URL, options.AuthzCalloutOptions{
+		CacheTTL:  100 * time.Millisecond,
+		CacheSize: 2,
+	})
+	doc := authzCalloutRequest{User: "alice", Method: http.MethodGet, Path: "/"}
+
+	c.authorize(context.Background(), doc)
+	c.authorize(context.Background(), doc)
+	if got := service.callCount(); got != 1 {
+		t.Fatalf("identical documents within the TTL: got %d calls, want 1", got)
+	}
+
+	c.authorize(context.Background(), authzCalloutRequest{User: "alice", Method: http.MethodGet, Path: "/other"})
+	if got := service.callCount(); got != 2 {
+		t.Fatalf("another document: got %d calls, want 2", got)
+	}
+
+	c.authorize(context.Background(), authzCalloutRequest{User: "bob", Method: http.MethodGet, Path: "/"})
+	c.authorize(context.Background(), authzCalloutRequest{User: "carol", Method: http.MethodGet, Path: "/"})
+	c.mu.Lock()
+	cached := len(c.decisions)
+	c.mu.Unlock()
+	if cached != 2 {
+		t.Fatalf("cache size: got %d decisions, want 2", cached)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	c.authorize(context.Background(), authzCalloutRequest{User: "carol", Method: http.MethodGet, Path: "/"})
+	if got := service.callCount(); got != 5 {
+		t.Fatalf("document after the TTL: got %d calls, want 5", got)
+	}
+}
+
+func TestAuthzCalloutFailure(t *testing.T) {
+	service := newTestAuthzService(t, map[string]map[string]string{"alice": nil})
+	service.delay = 200 * time.Millisecond
+	doc := authzCalloutRequest{User: "alice", Method: http.MethodGet, Path: "/"}
+
+	closed := newTestAuthzCallout(service.URL, options.AuthzCalloutOptions{Timeout: 20 * time.Millisecond})
+	if decision := closed.authorize(context.Background(), doc); decision.Allow {
+		t.Errorf("timeout failing closed: got %+v", decision)
+	}
+
+	open := newTestAuthzCallout(service.URL, options.AuthzCalloutOptions{Timeout: 20 * time.Millisecond, FailOpen: true})
+	if decision := open.authorize(context.Background(), doc); !decision.Allow {
+		t.Errorf("timeout failing open: got %+v", decision)
+	}
+
+	broken := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	closed = newTestAuthzCallout(broken.URL, options.AuthzCalloutOptions{})
+	if decision := closed.authorize(context.Background(), doc); decision.Allow {
+		t.Errorf("server error failing closed: got %+v", decision)
+	}
+	open = newTestAuthzCallout(broken.URL, options.AuthzCalloutOptions{FailOpen: true})
+	if decision := open.authorize(context.Background(), doc); !decision.Allow {
+		t.Errorf("server error failing open: got %+v", decision)
+	}
+}
+
+func TestProxyAuthzCallout(t *testing.T) {
+	service := newTestAuthzService(t, map[string]map[string]string{
+		"alice": {"X-Tenant": "blue"},
+	})
+	p := newTestProxy(t, map[string]interface{}{"authz_callout_url": service.URL, "authz_callout_headers": []interface{}{"X-Tenant"}})
+
+	alice := sessionCookie(t, p, &sessionsapi.SessionState{User: "alice", Email: "alice@example.com"})
+	rec := serve(p, http.MethodGet, "/", alice)
+	if rec.Code < 200 || rec.Code > 299 {
+		t.Fatalf("allowed browser request: got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-Tenant"); got != "blue" {
+		t.Errorf("allowed browser request: X-Tenant %q, want %q", got, "blue")
+	}
+
+	bob := sessionCookie(t, p, &sessionsapi.SessionState{User: "bob", Email: "bob@example.com"})
+	if rec := serve(p, http.MethodGet, "/", bob); rec.Code != http.StatusForbidden {
+		t.Errorf("denied browser request: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	// A new proxy, so alice's decision isn't cached
+	service.Close()
+	p = newTestProxy(t, map[string]interface{}{"authz_callout_url": service.URL, "authz_callout_headers": []interface{}{"X-Tenant"}})
+	if rec := serve(p, http.MethodGet, "/", alice); rec.Code != http.StatusForbidden {
+		t.Errorf("browser request with the service down: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
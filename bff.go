@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
+)
+
+// tokenResponse is the JSON document served by the BFF token endpoint
+type tokenResponse struct {
+	AccessToken string     `json:"accessToken"`
+	ExpiresOn   *time.Time `json:"expiresOn,omitempty"`
+	ExpiresIn   int64      `json:"expiresIn,omitempty"`
+}
+
+// Token returns the session's current access token to same-origin
+// JavaScript (the backend-for-frontend pattern). The session chain has
+// already refreshed the session if it was due. Requests must carry the
+// anti-CSRF header and an Origin matching this host.
+func (p *OAuthProxy) Token(rw http.ResponseWriter, req *http.Request) {
+	if err := p.checkAntiCSRF(req); err != nil {
+		logger.PrintAuthf("", req, logger.AuthFailure, "Rejected token request: %v", err)
+		writeOAuthError(rw, http.StatusForbidden, "forbidden", err.Error())
+		return
+	}
+	if req.Header.Get("Origin") == "" {
+		writeOAuthError(rw, http.StatusForbidden, "forbidden", "missing Origin header")
+		return
+	}
+
+	session, ok := p.sessionOrError(rw, req)
+	if !ok {
+		return
+	}
+	if session.AccessToken == "" {
+		writeOAuthError(rw, http.StatusNotFound, "not_found", "the session holds no access token")
+		return
+	}
+
+	resp := tokenResponse{
+		AccessToken: session.AccessToken,
+		ExpiresOn:   session.ExpiresOn,
+	}
+	if session.ExpiresOn != nil && !session.ExpiresOn.IsZero() {
+		resp.ExpiresIn = int64(time.Until(*session.ExpiresOn).Seconds())
+	}
+	writeJSON(rw, http.StatusOK, resp)
+}
+
+// checkAntiCSRF ensures the request carries the configured anti-CSRF header
+// and, when the browser sent one, an Origin header matching this host.
+func (p *OAuthProxy) checkAntiCSRF(req *http.Request) error {
+	if req.Header.Get(p.bffOptions.CSRFHeader) == "" {
+		return fmt.Errorf("missing %s header", p.bffOptions.CSRFHeader)
+	}
+
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if !strings.EqualFold(origin, p.requestOrigin(req)) {
+		return errors.New("origin does not match")
+	}
+	return nil
+}
+
+// requestOrigin returns the origin (scheme and host) the request was sent to
+func (p *OAuthProxy) requestOrigin(req *http.Request) string {
+	scheme := requestutil.GetRequestProto(req)
+	if scheme == "" {
+		scheme = schemeHTTP
+	}
+	if p.CookieOptions.Secure {
+		scheme = schemeHTTPS
+	}
+	return scheme + "://" + requestutil.GetRequestHost(req)
+}
+
+// isStateChanging reports whether the method may change state on the upstream
+func isStateChanging(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"oidc/pkg/apis/options"
+	sessionsapi "oidc/pkg/apis/sessions"
+	"oidc/pkg/encryption"
+	"oidc/pkg/ip"
+	"oidc/providers"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"golang.org/x/oauth2"
+)
+
+const (
+	deviceAuthorizePath = "/device/authorize"
+	deviceTokenPath     = "/device/token"
+
+	// deviceAuthorizationTimeout bounds how long the proxy polls for a
+	// device authorization when the provider didn't say when it expires
+	deviceAuthorizationTimeout = 15 * time.Minute
+
+	// deviceResultRetention is how long a finished device authorization
+	// waits for the client to collect its result
+	deviceResultRetention = 5 * time.Minute
+)
+
+// deviceAuthorizeResponse is the JSON document returned to the client when
+// a device authorization was started
+type deviceAuthorizeResponse struct {
+	DeviceID                string `json:"deviceId"`
+	UserCode                string `json:"userCode"`
+	VerificationURI         string `json:"verificationUri"`
+	VerificationURIComplete string `json:"verificationUriComplete,omitempty"`
+	ExpiresIn               int64  `json:"expiresIn,omitempty"`
+	Interval                int64  `json:"interval,omitempty"`
+}
+
+// deviceTicketResponse hands a device flow session to the client as the
+// session cookie to send with its requests
+type deviceTicketResponse struct {
+	Cookie    string     `json:"cookie"`
+	ExpiresOn *time.Time `json:"expiresOn,omitempty"`
+}
+
+// deviceBearerResponse hands a device flow session to the client as its
+// tokens
+type deviceBearerResponse struct {
+	TokenType   string     `json:"tokenType"`
+	AccessToken string     `json:"accessToken"`
+	IDToken     string     `json:"idToken,omitempty"`
+	ExpiresOn   *time.Time `json:"expiresOn,omitempty"`
+	ExpiresIn   int64      `json:"expiresIn,omitempty"`
+}
+
+// deviceAuthorization tracks a device authorization the proxy is polling
+// the provider for
+type deviceAuthorization struct {
+	clientIP string
+	expires  time.Time
+	done     bool
+	session  *sessionsapi.SessionState
+	err      error
+}
+
+// deviceAuthorizations holds the pending device authorizations by the
+// device ID handed to the client. Each one costs a goroutine polling the
+// provider, so their number is bounded overall and per client IP.
+type deviceAuthorizations struct {
+	maxPending          int
+	maxPendingPerClient int
+
+	mu      sync.Mutex
+	pending map[string]*deviceAuthorization
+}
+
+func newDeviceAuthorizations(maxPending, maxPendingPerClient int) *deviceAuthorizations {
+	return &deviceAuthorizations{
+		maxPending:          maxPending,
+		maxPendingPerClient: maxPendingPerClient,
+		pending:             make(map[string]*deviceAuthorization),
+	}
+}
+
+// reserve holds a place for a device authorization from the client until
+// expires. It returns false when the limits are reached.
+func (d *deviceAuthorizations) reserve(id, clientIP string, expires time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	fromClient := 0
+	for key, auth := range d.pending {
+		if now.After(auth.expires) {
+			delete(d.pending, key)
+			continue
+		}
+		if auth.clientIP == clientIP {
+			fromClient++
+		}
+	}
+	if len(d.pending) >= d.maxPending || fromClient >= d.maxPendingPerClient {
+		return false
+	}
+	d.pending[id] = &deviceAuthorization{clientIP: clientIP, expires: expires}
+	return true
+}
+
+// start sets when a reserved device authorization expires, now that the
+// provider has said
+func (d *deviceAuthorizations) start(id string, expires time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if auth, ok := d.pending[id]; ok {
+		auth.expires = expires
+	}
+}
+
+// release removes a reserved device authorization that couldn't be started
+func (d *deviceAuthorizations) release(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.pending, id)
+}
+
+func (d *deviceAuthorizations) finish(id string, session *sessionsapi.SessionState, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	auth, ok := d.pending[id]
+	if !ok {
+		return
+	}
+	auth.done = true
+	auth.session = session
+	auth.err = err
+	auth.expires = time.Now().Add(deviceResultRetention)
+}
+
+// take returns the device authorization, removing it once it is finished so
+// its result can only be collected once
+func (d *deviceAuthorizations) take(id string) (deviceAuthorization, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	auth, ok := d.pending[id]
+	if !ok || time.Now().After(auth.expires) {
+		delete(d.pending, id)
+		return deviceAuthorization{}, false
+	}
+	if auth.done {
+		delete(d.pending, id)
+	}
+	return *auth, true
+}
+
+// DeviceAuthorize starts an RFC 8628 device authorization at the provider
+// and returns the user code and verification URI for the client to show.
+// The proxy then polls the provider until the user has approved it.
+func (p *OAuthProxy) DeviceAuthorize(rw http.ResponseWriter, req *http.Request) {
+	nonce, err := encryption.Nonce(32)
+	if err != nil {
+		logger.Errorf("Error generating device ID: %v", err)
+		writeOAuthError(rw, http.StatusInternalServerError, "server_error", "unable to start device authorization")
+		return
+	}
+	id := base64.RawURLEncoding.EncodeToString(nonce)
+
+	// Hold a place before asking the provider, so clients over the limits
+	// never reach it
+	clientIP := ip.GetClientString(p.realClientIPParser, req, false)
+	if !p.deviceAuthorizations.reserve(id, clientIP, time.Now().Add(deviceAuthorizationTimeout)) {
+		logger.Printf("Too many pending device authorizations, rejecting request from %s", clientIP)
+		writeOAuthError(rw, http.StatusTooManyRequests, "temporarily_unavailable", "too many pending device authorizations")
+		return
+	}
+
+	da, err := p.provider.StartDeviceAuthorization(req.Context())
+	if err != nil {
+		p.deviceAuthorizations.release(id)
+	}
+	if errors.Is(err, providers.ErrNotImplemented) {
+		writeOAuthError(rw, http.StatusNotImplemented, "unsupported_grant_type", "the provider does not support the device authorization grant")
+		return
+	}
+	if err != nil {
+		logger.Errorf("Error starting device authorization: %v", err)
+		writeOAuthError(rw, http.StatusBadGateway, "server_error", "unable to start device authorization")
+		return
+	}
+
+	if da.Expiry.IsZero() {
+		da.Expiry = time.Now().Add(deviceAuthorizationTimeout)
+	}
+	p.deviceAuthorizations.start(id, da.Expiry)
+
+	// Detach from the request so polling can outlive it
+	pollReq := req.Clone(context.WithoutCancel(req.Context()))
+	go p.pollDeviceAuthorization(pollReq, id, da)
+
+	writeJSON(rw, http.StatusOK, deviceAuthorizeResponse{
+		DeviceID:                id,
+		UserCode:                da.UserCode,
+		VerificationURI:         da.VerificationURI,
+		VerificationURIComplete: da.VerificationURIComplete,
+		ExpiresIn:               int64(time.Until(da.Expiry).Seconds()),
+		Interval:                da.Interval,
+	})
+}
+
+// pollDeviceAuthorization waits for the provider to issue tokens for the
+// device authorization and checks the resulting session the same way as
+// the OAuth callback does
+func (p *OAuthProxy) pollDeviceAuthorization(req *http.Request, id string, da *oauth2.DeviceAuthResponse) {
+	ctx := req.Context()
+	session, err := p.provider.RedeemDeviceCode(ctx, da)
+	if err != nil {
+		logger.Errorf("Error redeeming device code: %v", err)
+		p.deviceAuthorizations.finish(id, nil, err)
+		return
+	}
+	p.initSession(req, session)
+
+	if err := p.enrichSessionState(ctx, session); err != nil {
+		logger.Errorf("Error creating session from device authorization: %v", err)
+		p.deviceAuthorizations.finish(id, nil, err)
+		return
+	}
+	if !p.provider.ValidateSession(ctx, session) {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Session validation failed: %s", session)
+		p.deviceAuthorizations.finish(id, nil, ErrAccessDenied)
+		return
+	}
+
+	authorized, err := p.provider.Authorize(ctx, session)
+	if err != nil {
+		logger.Errorf("Error with authorization: %v", err)
+	}
+	if !p.Validator(session.Email) || !authorized {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via device authorization: unauthorized")
+		p.deviceAuthorizations.finish(id, nil, ErrAccessDenied)
+		return
+	}
+
+	logger.PrintAuthf(session.Email, req, logger.AuthSuccess, "Authenticated via device authorization: %s", session)
+	p.deviceAuthorizations.finish(id, session, nil)
+}
+
+// DeviceToken is polled by the client with its device ID. Until the user
+// has approved the device authorization it answers `authorization_pending`,
+// afterwards it hands over the session once.
+func (p *OAuthProxy) DeviceToken(rw http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writeOAuthError(rw, http.StatusBadRequest, "invalid_request", "unable to parse request body")
+		return
+	}
+	id := req.PostForm.Get("device_id")
+	if id == "" {
+		writeOAuthError(rw, http.StatusBadRequest, "invalid_request", "missing device_id")
+		return
+	}
+
+	auth, ok := p.deviceAuthorizations.take(id)
+	switch {
+	case !ok:
+		writeOAuthError(rw, http.StatusBadRequest, "expired_token", "unknown or expired device_id")
+		return
+	case !auth.done:
+		writeOAuthError(rw, http.StatusBadRequest, "authorization_pending", "the device authorization has not been approved yet")
+		return
+	case auth.err != nil:
+		errorCode := deviceErrorCode(auth.err)
+		writeOAuthError(rw, http.StatusBadRequest, errorCode, "the device authorization failed")
+		return
+	}
+
+	session := auth.session
+	if p.deviceFlowResponse == options.DeviceFlowResponseBearer {
+		resp := deviceBearerResponse{
+			TokenType:   "Bearer",
+			AccessToken: session.AccessToken,
+			IDToken:     session.IDToken,
+			ExpiresOn:   session.ExpiresOn,
+		}
+		if session.ExpiresOn != nil && !session.ExpiresOn.IsZero() {
+			resp.ExpiresIn = int64(time.Until(*session.ExpiresOn).Seconds())
+		}
+		writeJSON(rw, http.StatusOK, resp)
+		return
+	}
+
+	// Capture the session cookie the store would set on a browser
+	rec := &headerRecorder{header: http.Header{}}
+	if err := p.SaveSession(rec, req, session); err != nil {
+		logger.PrintAuthf(session.Email, req, logger.AuthError, "error saving session: %v", err)
+		writeOAuthError(rw, http.StatusInternalServerError, "server_error", "unable to save session")
+		return
+	}
+	cookies := (&http.Response{Header: rec.header}).Cookies()
+	pairs := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+	writeJSON(rw, http.StatusOK, deviceTicketResponse{
+		Cookie:    strings.Join(pairs, "; "),
+		ExpiresOn: session.ExpiresOn,
+	})
+}
+
+// deviceErrorCode maps the failure of a device authorization to the RFC 8628
+// error returned to the client
+func deviceErrorCode(err error) string {
+	var retrieveErr *oauth2.RetrieveError
+	switch {
+	case errors.Is(err, ErrAccessDenied):
+		return "access_denied"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "expired_token"
+	case errors.As(err, &retrieveErr) && retrieveErr.ErrorCode != "":
+		return retrieveErr.ErrorCode
+	default:
+		return "server_error"
+	}
+}
+
+// headerRecorder is a http.ResponseWriter that only keeps the headers
+type headerRecorder struct {
+	header http.Header
+}
+
+func (h *headerRecorder) Header() http.Header         { return h.header }
+func (h *headerRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (h *headerRecorder) WriteHeader(int)             {}
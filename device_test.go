@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postForm(p *OAuthProxy, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	p.serveMux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDeviceFlow(t *testing.T) {
+	idp := newTestIdP(t)
+	p := newTestProxy(t, idp.config(map[string]interface{}{"device_flow": true}))
+
+	rec := postForm(p, "/oauth2"+deviceAuthorizePath, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("authorize: got %d %s", rec.Code, rec.Body)
+	}
+	var authorize deviceAuthorizeResponse
+	decodeJSON(t, rec, &authorize)
+	if authorize.DeviceID == "" || authorize.UserCode != "USER-CODE" || authorize.VerificationURI != idp.URL+"/verify" {
+		t.Fatalf("authorize: unexpected response %+v", authorize)
+	}
+
+	form := url.Values{"device_id": {authorize.DeviceID}}
+	rec = postForm(p, "/oauth2"+deviceTokenPath, form)
+	var pending map[string]string
+	decodeJSON(t, rec, &pending)
+	if rec.Code != http.StatusBadRequest || pending["error"] != "authorization_pending" {
+		t.Fatalf("token before approval: got %d %s", rec.Code, rec.Body)
+	}
+
+	// The proxy polls the IdP once a second, and gets tokens on its
+	// second poll
+	deadline := time.Now().Add(10 * time.Second)
+	for rec.Code != http.StatusOK && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		rec = postForm(p, "/oauth2"+deviceTokenPath, form)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("token: got %d %s", rec.Code, rec.Body)
+	}
+	var ticket deviceTicketResponse
+	decodeJSON(t, rec, &ticket)
+	if ticket.Cookie == "" {
+		t.Fatal("token: no session cookie")
+	}
+
+	// The cookie authenticates the client's requests
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Cookie", ticket.Cookie)
+	rec = httptest.NewRecorder()
+	p.serveMux.ServeHTTP(rec, req)
+	if rec.Code < 200 || rec.Code > 299 {
+		t.Fatalf("request with the session cookie: got %d %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("GAP-Auth"); got != "user@example.com" {
+		t.Fatalf("request with the session cookie: GAP-Auth %q", got)
+	}
+
+	// The result is handed over once
+	rec = postForm(p, "/oauth2"+deviceTokenPath, form)
+	var expired map[string]string
+	decodeJSON(t, rec, &expired)
+	if expired["error"] != "expired_token" {
+		t.Fatalf("second token request: got %d %s", rec.Code, rec.Body)
+	}
+}
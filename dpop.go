@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"oidc/pkg/apis/options"
+	sessionsapi "oidc/pkg/apis/sessions"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
+)
+
+// dpopRoute is a compiled options.DPoPRoute
+type dpopRoute struct {
+	path *regexp.Regexp
+	url  string
+}
+
+func buildDPoPRoutes(opts *options.Options) ([]dpopRoute, error) {
+	routes := make([]dpopRoute, 0, len(opts.DPoPRoutes))
+	for _, route := range opts.DPoPRoutes {
+		path, err := regexp.Compile(route.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling DPoP route path %q: %v", route.Path, err)
+		}
+		routes = append(routes, dpopRoute{path: path, url: route.URL})
+	}
+	return routes, nil
+}
+
+// dpopRequirement returns the first DPoP route matching the request
+func (p *OAuthProxy) dpopRequirement(req *http.Request) *dpopRoute {
+	for i := range p.dpopRoutes {
+		if p.dpopRoutes[i].path.MatchString(req.URL.Path) {
+			return &p.dpopRoutes[i]
+		}
+	}
+	return nil
+}
+
+// addUpstreamDPoP passes the access token for the request's DPoP route with
+// the DPoP scheme and a proof bound to the upstream request. A token already
+// exchanged for the upstream is used instead of the session's. If no proof
+// could be signed an error response has been written and false is returned.
+// The upstream's `DPoP-Nonce` never reaches the proxy, so the proof has no
+// nonce.
+func (p *OAuthProxy) addUpstreamDPoP(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) bool {
+	route := p.dpopRequirement(req)
+	if route == nil {
+		return true
+	}
+
+	accessToken := session.AccessToken
+	if exchanged, ok := strings.CutPrefix(rw.Header().Get("Authorization"), "Bearer "); ok {
+		accessToken = exchanged
+	}
+
+	// Behind an auth request the proof is for the request being authorized
+	method := req.Header.Get("X-Forwarded-Method")
+	if method == "" {
+		method = req.Method
+	}
+	// The proof is bound to the request's URI at the upstream, ignoring the
+	// query, so the route's base URL only replaces the origin
+	uri := requestutil.GetRequestURI(req)
+	target := requestutil.GetRequestProto(req) + "://" + requestutil.GetRequestHost(req) + uri
+	if route.url != "" {
+		target = strings.TrimSuffix(route.url, "/") + uri
+	}
+
+	proof, err := p.provider.DPoPProof(method, target, accessToken)
+	if err != nil {
+		logger.PrintAuthf(session.Email, req, logger.AuthError, "Error signing DPoP proof for %s: %v", route.path, err)
+		writeOAuthError(rw, http.StatusInternalServerError, "server_error", "unable to create a DPoP proof for the upstream")
+		return false
+	}
+
+	rw.Header().Set("Authorization", "DPoP "+accessToken)
+	rw.Header().Set("DPoP", proof)
+	return true
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	middlewareapi "oidc/pkg/apis/middleware"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// extAuthzServer implements the Envoy `envoy.service.auth.v3.Authorization`
+// gRPC service. Requests are authenticated like the ones sent to the proxy,
+// with the same session loaders and authorization checks.
+type extAuthzServer struct {
+	authv3.UnimplementedAuthorizationServer
+	proxy *OAuthProxy
+}
+
+// serveExtAuthz serves the ext_authz service on address until it fails
+func serveExtAuthz(address string, p *OAuthProxy) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %v", address, err)
+	}
+	server := grpc.NewServer()
+	authv3.RegisterAuthorizationServer(server, &extAuthzServer{proxy: p})
+	return server.Serve(listener)
+}
+
+// Check authorizes the request Envoy describes. Allowed requests are
+// answered with the headers to add upstream, others with the response the
+// proxy would have sent, such as a redirect to the start endpoint.
+func (s *extAuthzServer) Check(ctx context.Context, check *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	req, err := extAuthzRequest(ctx, check)
+	if err != nil {
+		logger.Errorf("Invalid ext_authz check request: %v", err)
+		return extAuthzDenied(http.StatusBadRequest, nil, "invalid request"), nil
+	}
+
+	rw := &extAuthzResponseWriter{header: make(http.Header)}
+	var allowed, bearer bool
+	handler := s.proxy.preAuthChain.Then(s.proxy.sessionChain.ThenFunc(func(rw http.ResponseWriter, req *http.Request) {
+		allowed = s.proxy.checkExtAuthz(rw, req)
+		bearer = middlewareapi.GetRequestScope(req).BearerSession
+	}))
+	handler.ServeHTTP(rw, req)
+
+	if !allowed {
+		return extAuthzDenied(rw.statusCode(), rw.header, rw.body.String()), nil
+	}
+	return extAuthzOK(rw.header, s.proxy.upstreamHeaders(), bearer), nil
+}
+
+// checkExtAuthz decides an ext_authz request the way Proxy decides a proxied
+// one, and reports whether it is allowed. When it isn't, the response to
+// send to the client has been written.
+func (p *OAuthProxy) checkExtAuthz(rw http.ResponseWriter, req *http.Request) bool {
+	session, err := p.getAuthenticatedSession(rw, req)
+	switch {
+	case err == nil:
+		return p.prepareUpstream(rw, req, session)
+	case errors.Is(err, ErrNeedsLogin):
+		if isAjax(req) || middlewareapi.GetRequestScope(req).BearerError != "" {
+			p.writeLoginRequired(rw, req)
+			return false
+		}
+		http.Redirect(rw, req, p.loginURL(req), http.StatusFound)
+	case errors.Is(err, ErrAccessDenied):
+		p.writeAccessDenied(rw, req)
+	default:
+		logger.Errorf("Unexpected internal error: %v", err)
+		writeOAuthError(rw, http.StatusInternalServerError, "server_error", "unable to authorize the request")
+	}
+	return false
+}
+
+// extAuthzRequest rebuilds the HTTP request from the attributes Envoy sent
+func extAuthzRequest(ctx context.Context, check *authv3.CheckRequest) (*http.Request, error) {
+	attrs := check.GetAttributes().GetRequest().GetHttp()
+	if attrs == nil {
+		return nil, errors.New("missing HTTP request attributes")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, attrs.GetMethod(), attrs.GetPath(), strings.NewReader(attrs.GetBody()))
+	if err != nil {
+		return nil, err
+	}
+	req.Host = attrs.GetHost()
+	req.URL.Scheme = attrs.GetScheme()
+	req.RequestURI = attrs.GetPath()
+	for name, value := range attrs.GetHeaders() {
+		// Skip HTTP/2 pseudo headers such as `:authority`
+		if strings.HasPrefix(name, ":") {
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+
+	if source := check.GetAttributes().GetSource().GetAddress().GetSocketAddress(); source != nil {
+		req.RemoteAddr = net.JoinHostPort(source.GetAddress(), strconv.FormatUint(uint64(source.GetPortValue()), 10))
+	}
+	return req, nil
+}
+
+// extAuthzOK allows the request, adding the upstream headers set by the
+// proxy to it. The client's own values of upstream headers the proxy didn't
+// set are removed, so the upstream can trust them, except for the
+// Authorization header of bearer sessions, which carries the token the
+// session was created from. Cookies the proxy set are returned to the client.
+func extAuthzOK(header http.Header, upstream []string, bearer bool) *authv3.CheckResponse {
+	ok := &authv3.OkHttpResponse{}
+	ok.ResponseHeadersToAdd = extAuthzHeaders("Set-Cookie", header.Values("Set-Cookie"), true)
+	for _, name := range upstream {
+		values := header.Values(name)
+		if len(values) > 0 {
+			ok.Headers = append(ok.Headers, extAuthzHeaders(name, values[:1], false)...)
+			ok.Headers = append(ok.Headers, extAuthzHeaders(name, values[1:], true)...)
+			continue
+		}
+		if !(bearer && name == "Authorization") {
+			ok.HeadersToRemove = append(ok.HeadersToRemove, name)
+		}
+	}
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: ok},
+	}
+}
+
+// extAuthzDenied answers the client with the given response instead of
+// forwarding the request
+func extAuthzDenied(status int, header http.Header, body string) *authv3.CheckResponse {
+	code := codes.PermissionDenied
+	if status == http.StatusUnauthorized {
+		code = codes.Unauthenticated
+	}
+	denied := &authv3.DeniedHttpResponse{
+		Status: &typev3.HttpStatus{Code: typev3.StatusCode(status)},
+		Body:   body,
+	}
+	for name, values := range header {
+		denied.Headers = append(denied.Headers, extAuthzHeaders(name, values, name == "Set-Cookie")...)
+	}
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(code)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: denied},
+	}
+}
+
+// extAuthzHeaders converts the values of a header. Unless they are appended,
+// they replace any header of the same name.
+func extAuthzHeaders(name string, values []string, appendValues bool) []*corev3.HeaderValueOption {
+	options := make([]*corev3.HeaderValueOption, 0, len(values))
+	for _, value := range values {
+		option := &corev3.HeaderValueOption{
+			Header: &corev3.HeaderValue{Key: name, Value: value},
+			Append: wrapperspb.Bool(appendValues),
+		}
+		options = append(options, option)
+	}
+	return options
+}
+
+// extAuthzResponseWriter captures the response the proxy writes for an
+// ext_authz request
+type extAuthzResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *extAuthzResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *extAuthzResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *extAuthzResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// statusCode is the status of a denied request. A denial that didn't write a
+// status is answered as forbidden.
+func (w *extAuthzResponseWriter) statusCode() int {
+	if w.status == 0 || w.status == http.StatusOK {
+		return http.StatusForbidden
+	}
+	return w.status
+}
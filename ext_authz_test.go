@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestExtAuthzOK(t *testing.T) {
+	header := http.Header{}
+	header.Set("GAP-Auth", "user@example.com")
+	header.Add("X-Tenant", "blue")
+	header.Add("X-Tenant", "green")
+	header.Add("Set-Cookie", "a=1")
+	header.Add("Set-Cookie", "b=2")
+	prepareNoCache(headerWriter(header))
+
+	ok := extAuthzOK(header, []string{"GAP-Auth", "Authorization", "DPoP", "X-Tenant"}, false).GetOkResponse()
+
+	var added []string
+	for _, option := range ok.Headers {
+		entry := option.Header.Key + ": " + option.Header.Value
+		if option.Append.GetValue() {
+			entry += " (appended)"
+		}
+		added = append(added, entry)
+	}
+	want := []string{"GAP-Auth: user@example.com", "X-Tenant: blue", "X-Tenant: green (appended)"}
+	if !reflect.DeepEqual(added, want) {
+		t.Errorf("upstream headers: got %q, want %q", added, want)
+	}
+	if want := []string{"Authorization", "DPoP"}; !reflect.DeepEqual(ok.HeadersToRemove, want) {
+		t.Errorf("removed headers: got %q, want %q", ok.HeadersToRemove, want)
+	}
+	if len(ok.ResponseHeadersToAdd) != 2 {
+		t.Errorf("client headers: got %d, want the 2 cookies", len(ok.ResponseHeadersToAdd))
+	}
+
+	bearer := extAuthzOK(http.Header{}, []string{"GAP-Auth", "Authorization"}, true).GetOkResponse()
+	if want := []string{"GAP-Auth"}; !reflect.DeepEqual(bearer.HeadersToRemove, want) {
+		t.Errorf("bearer session removed headers: got %q, want %q", bearer.HeadersToRemove, want)
+	}
+}
+
+// headerWriter is a ResponseWriter that only has headers
+type headerWriter http.Header
+
+func (w headerWriter) Header() http.Header         { return http.Header(w) }
+func (w headerWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w headerWriter) WriteHeader(int)             {}
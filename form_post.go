@@ -0,0 +1,91 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"oidc/pkg/apis/options"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// formPostResubmitField marks a form_post callback re-submitted by the
+// interstitial, so a callback still missing its CSRF cookie isn't
+// re-submitted again
+const formPostResubmitField = "oauth2_proxy_resubmit"
+
+// formPostResubmitTemplate POSTs the callback parameters back to the callback
+// from the proxy's own origin. The browser then sends the SameSite=Lax CSRF
+// cookie it withheld from the cross-site POST.
+var formPostResubmitTemplate = template.Must(template.New("resubmit").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Signing in</title></head>
+<body>
+<form method="post" action="{{.Action}}">
+{{- range $name, $values := .Params}}{{range $values}}
+  <input type="hidden" name="{{$name}}" value="{{.}}">
+{{- end}}{{end}}
+  <input type="hidden" name="` + formPostResubmitField + `" value="1">
+  <noscript><button type="submit">Continue</button></noscript>
+</form>
+<script>document.forms[0].submit();</script>
+</body>
+</html>
+`))
+
+type formPostResubmit struct {
+	Action string
+	Params url.Values
+}
+
+// buildCSRFCookieOptions returns the cookie options of the CSRF cookie. For
+// form_post callbacks relying on SameSite=None it is issued cross-site.
+func buildCSRFCookieOptions(opts *options.Options) *options.Cookie {
+	if opts.Providers[0].ResponseMode != options.ResponseModeFormPost || opts.FormPostCSRF != options.FormPostCSRFSameSiteNone {
+		return &opts.Cookie
+	}
+	csrfOpts := opts.Cookie
+	csrfOpts.SameSite = "none"
+	return &csrfOpts
+}
+
+// resubmitFormPost answers a cross-site form_post callback without a CSRF
+// cookie with a page re-submitting it from the proxy's origin. It returns
+// whether the interstitial was written.
+func (p *OAuthProxy) resubmitFormPost(rw http.ResponseWriter, req *http.Request) bool {
+	if req.Method != http.MethodPost || p.formPostCSRF != options.FormPostCSRFInterstitial {
+		return false
+	}
+	if req.PostForm.Has(formPostResubmitField) || p.hasCSRFCookie(req) {
+		req.PostForm.Del(formPostResubmitField)
+		req.Form.Del(formPostResubmitField)
+		return false
+	}
+
+	prepareNoCache(rw)
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	err := formPostResubmitTemplate.Execute(rw, formPostResubmit{
+		Action: p.ProxyPrefix + oauthCallbackPath,
+		Params: req.PostForm,
+	})
+	if err != nil {
+		logger.Errorf("Error rendering form_post interstitial: %v", err)
+	}
+	return true
+}
+
+// hasCSRFCookie reports whether the request carries any CSRF cookie. With
+// per-request CSRF cookies their name depends on the state, which may only
+// be readable once a JARM response was verified.
+func (p *OAuthProxy) hasCSRFCookie(req *http.Request) bool {
+	prefix := p.csrfCookieOptions.Name + "_csrf"
+	for _, c := range req.Cookies() {
+		if strings.HasPrefix(c.Name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"oidc/pkg/apis/options"
+	sessionsapi "oidc/pkg/apis/sessions"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+const (
+	impersonateHeaderPrefix      = "Impersonate-"
+	impersonateUserHeader        = "Impersonate-User"
+	impersonateGroupHeader       = "Impersonate-Group"
+	impersonateExtraHeaderPrefix = "Impersonate-Extra-"
+
+	// Requests allowed without a session are made as the API server's
+	// anonymous user rather than with the proxy's own permissions
+	kubernetesAnonymousUser        = "system:anonymous"
+	kubernetesUnauthenticatedGroup = "system:unauthenticated"
+
+	// The service account token is re-read this often, so projected tokens
+	// are picked up after the kubelet rotates them
+	kubernetesTokenReloadInterval = time.Minute
+)
+
+// kubernetesUpstream proxies requests to a Kubernetes API server,
+// authenticating as the proxy and impersonating the session's user
+type kubernetesUpstream struct {
+	opts  options.KubernetesOptions
+	proxy *httputil.ReverseProxy
+	token *kubernetesToken
+
+	// cookieName prefixes the proxy's own cookies, which aren't forwarded
+	cookieName string
+}
+
+// newKubernetesUpstream returns nil when no Kubernetes upstream is configured.
+// Cookies whose names start with cookieName are removed from the requests.
+func newKubernetesUpstream(opts options.KubernetesOptions, cookieName string) (*kubernetesUpstream, error) {
+	if opts.UpstreamURL == "" {
+		return nil, nil
+	}
+	target, err := url.Parse(opts.UpstreamURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Kubernetes upstream URL: %v", err)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.CAFile != "" {
+		ca, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading Kubernetes CA file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in Kubernetes CA file %q", opts.CAFile)
+		}
+	}
+	if opts.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading Kubernetes client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	k := &kubernetesUpstream{
+		opts:       opts,
+		cookieName: cookieName,
+		proxy: &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.SetURL(target)
+			},
+			Transport: transport,
+			ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+				logger.Errorf("Error proxying to Kubernetes upstream: %v", err)
+				writeOAuthError(rw, http.StatusBadGateway, "server_error", "the upstream is unavailable")
+			},
+		},
+	}
+	if opts.TokenFile != "" {
+		k.token = &kubernetesToken{path: opts.TokenFile}
+		if _, err := k.token.get(); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// serve proxies the request for the session, which is nil for requests
+// allowed without one. The upstream headers the proxy set are sent with the
+// request in place of the client's own, and only cookies are returned to the
+// client, the upstream's response deciding the rest. The proxy's cookies,
+// which hold the session and its tokens, are not sent.
+func (k *kubernetesUpstream) serve(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState, upstream []string) {
+	out := req.Clone(req.Context())
+	k.stripCookies(out)
+	for _, name := range upstream {
+		out.Header.Del(name)
+		if values := rw.Header().Values(name); len(values) > 0 {
+			out.Header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	for name := range rw.Header() {
+		if name != "Set-Cookie" {
+			rw.Header().Del(name)
+		}
+	}
+
+	if err := k.impersonate(out.Header, session); err != nil {
+		logger.Errorf("Unable to impersonate the session's user: %v", err)
+		writeOAuthError(rw, http.StatusForbidden, "access_denied", "the session has no user to impersonate")
+		return
+	}
+
+	out.Header.Del("Authorization")
+	if k.token != nil {
+		token, err := k.token.get()
+		if err != nil {
+			logger.Errorf("Error reading Kubernetes token: %v", err)
+			writeOAuthError(rw, http.StatusBadGateway, "server_error", "unable to authenticate to the upstream")
+			return
+		}
+		out.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	k.proxy.ServeHTTP(rw, out)
+}
+
+// stripCookies removes the proxy's cookies from the request, keeping the
+// others
+func (k *kubernetesUpstream) stripCookies(req *http.Request) {
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, c := range cookies {
+		if !strings.HasPrefix(c.Name, k.cookieName) {
+			req.AddCookie(c)
+		}
+	}
+}
+
+// impersonate replaces any impersonation headers in header with ones for the
+// session's user
+func (k *kubernetesUpstream) impersonate(header http.Header, session *sessionsapi.SessionState) error {
+	for name := range header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(name), impersonateHeaderPrefix) {
+			delete(header, name)
+		}
+	}
+
+	if session == nil {
+		header.Set(impersonateUserHeader, kubernetesAnonymousUser)
+		header.Set(impersonateGroupHeader, kubernetesUnauthenticatedGroup)
+		return nil
+	}
+
+	var claims map[string]interface{}
+	if k.opts.UserClaim != "" || k.opts.GroupsClaim != "" || len(k.opts.ExtraClaims) > 0 {
+		claims = sessionClaims(session)
+	}
+
+	// Sessions without the claims, such as bearer sessions without an ID
+	// token, fall back to the session's own user and groups
+	user := session.Email
+	if user == "" {
+		user = session.User
+	}
+	if claim, ok := claims[k.opts.UserClaim]; ok && k.opts.UserClaim != "" {
+		user = ""
+		if values := claimStrings(claim); len(values) == 1 {
+			user = values[0]
+		}
+	}
+	if user == "" {
+		return errors.New("no user to impersonate")
+	}
+	header.Set(impersonateUserHeader, k.opts.UserPrefix+user)
+
+	groups := session.Groups
+	if claim, ok := claims[k.opts.GroupsClaim]; ok && k.opts.GroupsClaim != "" {
+		groups = claimStrings(claim)
+	}
+	for _, group := range groups {
+		header.Add(impersonateGroupHeader, k.opts.GroupPrefix+group)
+	}
+
+	for key, claim := range k.opts.ExtraClaims {
+		values := claimStrings(claims[claim])
+		if len(values) > 0 {
+			// Set directly so the key isn't canonicalized
+			header[impersonateExtraHeaderPrefix+escapeExtraKey(key)] = values
+		}
+	}
+	return nil
+}
+
+// claimStrings returns the values of a string, number or bool claim, or of a
+// list of them
+func claimStrings(claim interface{}) []string {
+	switch v := claim.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, claimStrings(item)...)
+		}
+		return values
+	case string:
+		return []string{v}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case bool:
+		return []string{strconv.FormatBool(v)}
+	default:
+		return nil
+	}
+}
+
+// escapeExtraKey percent-encodes the characters of a user extra key that
+// aren't allowed in a header name, such as the `/` in `example.com/team`.
+// The API server decodes the key.
+func escapeExtraKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if isExtraKeyChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func isExtraKeyChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&'*+-.^_`|~", c) >= 0
+}
+
+// kubernetesToken is a service account token read from a file
+type kubernetesToken struct {
+	path string
+
+	mu    sync.Mutex
+	token string
+	read  time.Time
+}
+
+// get returns the token, re-reading the file when it was last read more than
+// kubernetesTokenReloadInterval ago. If the file can't be re-read, the token
+// last read is used.
+func (t *kubernetesToken) get() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && time.Since(t.read) < kubernetesTokenReloadInterval {
+		return t.token, nil
+	}
+
+	content, err := os.ReadFile(t.path)
+	if err == nil && strings.TrimSpace(string(content)) == "" {
+		err = fmt.Errorf("token file %q is empty", t.path)
+	}
+	if err != nil {
+		if t.token != "" {
+			t.read = time.Now()
+			logger.Errorf("Error re-reading Kubernetes token, using the previous one: %v", err)
+			return t.token, nil
+		}
+		return "", fmt.Errorf("error reading Kubernetes token: %v", err)
+	}
+	t.token = strings.TrimSpace(string(content))
+	t.read = time.Now()
+	return t.token, nil
+}
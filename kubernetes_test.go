@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	sessionsapi "oidc/pkg/apis/sessions"
+)
+
+// newTestKubernetesUpstream returns a server that records the headers of the
+// last request it received
+func newTestKubernetesUpstream(t *testing.T) (*httptest.Server, *http.Header) {
+	t.Helper()
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received = req.Header.Clone()
+		rw.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, &received
+}
+
+func TestKubernetesUpstream(t *testing.T) {
+	upstream, received := newTestKubernetesUpstream(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("service-account-token\n"), 0o600); err != nil {
+		t.Fatalf("writing token file: %v", err)
+	}
+	idp := newTestIdP(t)
+	p := newTestProxy(t, map[string]interface{}{
+		"kubernetes_upstream_url": upstream.URL,
+		"kubernetes_token_file":   tokenFile,
+		"kubernetes_user_prefix":  "oidc:",
+		"kubernetes_group_prefix": "oidc:",
+		"kubernetes_user_claim":   "preferred_username",
+		"kubernetes_groups_claim": "groups",
+		"kubernetes_extra_claims": map[string]interface{}{"example.com/team": "team"},
+	})
+
+	cookie := sessionCookie(t, p, &sessionsapi.SessionState{
+		User:    "user-1",
+		Email:   "user@example.com",
+		Groups:  []string{"staff"},
+		IDToken: idp.idToken(t, map[string]interface{}{"preferred_username": "jdoe", "groups": []string{"dev", "ops"}, "team": "blue"}),
+	})
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/pods", nil)
+	req.Header.Set("Cookie", cookie+"; other=kept")
+	req.Header.Set("Authorization", "Bearer client-token")
+	req.Header.Set("GAP-Auth", "admin@example.com")
+	req.Header.Set("Impersonate-User", "admin")
+	req.Header.Add("Impersonate-Group", "system:masters")
+	req.Header.Set("Impersonate-Extra-Scopes", "all")
+	rec := httptest.NewRecorder()
+	p.serveMux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("proxied request: got %d %s", rec.Code, rec.Body)
+	}
+
+	got := *received
+	if v := got.Get("Authorization"); v != "Bearer service-account-token" {
+		t.Errorf("Authorization: got %q", v)
+	}
+	if v := got.Get("GAP-Auth"); v != "user@example.com" {
+		t.Errorf("GAP-Auth: got %q", v)
+	}
+	if v := got.Values("Impersonate-User"); !reflect.DeepEqual(v, []string{"oidc:jdoe"}) {
+		t.Errorf("Impersonate-User: got %q", v)
+	}
+	if v := got.Values("Impersonate-Group"); !reflect.DeepEqual(v, []string{"oidc:dev", "oidc:ops"}) {
+		t.Errorf("Impersonate-Group: got %q", v)
+	}
+	if v := got.Values("Impersonate-Extra-example.com%2Fteam"); !reflect.DeepEqual(v, []string{"blue"}) {
+		t.Errorf("Impersonate-Extra-example.com%%2Fteam: got %q", v)
+	}
+	if v := got.Get("Impersonate-Extra-Scopes"); v != "" {
+		t.Errorf("client's Impersonate-Extra-Scopes: got %q", v)
+	}
+	if v := got.Get("Cookie"); v != "other=kept" {
+		t.Errorf("Cookie: got %q, want only the client's own cookie", v)
+	}
+	for name := range noCacheHeaders {
+		if v := got.Get(name); v != "" {
+			t.Errorf("%s: got %q, want it only on the response", name, v)
+		}
+	}
+
+	// Without an ID token, the session's user and groups are impersonated
+	cookie = sessionCookie(t, p, &sessionsapi.SessionState{
+		User:   "user-2",
+		Email:  "other@example.com",
+		Groups: []string{"staff"},
+	})
+	if rec := serve(p, http.MethodGet, "/api/v1/pods", cookie); rec.Code != http.StatusOK {
+		t.Fatalf("request without an ID token: got %d %s", rec.Code, rec.Body)
+	}
+	got = *received
+	if v := got.Values("Impersonate-User"); !reflect.DeepEqual(v, []string{"oidc:other@example.com"}) {
+		t.Errorf("Impersonate-User without an ID token: got %q", v)
+	}
+	if v := got.Values("Impersonate-Group"); !reflect.DeepEqual(v, []string{"oidc:staff"}) {
+		t.Errorf("Impersonate-Group without an ID token: got %q", v)
+	}
+}
@@ -5,42 +5,52 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"github.com/tidwall/gjson"
-	"log"
 	"net/http"
 	"oidc/pkg/apis/options"
+	"oidc/pkg/metrics"
 	"oidc/pkg/validation"
 	"os"
 )
 
-func main() {
+// configFile is the JSON configuration read at startup
+const configFile = "data.json"
 
-	content, err := os.ReadFile("data.json")
-	if err != nil {
-		log.Fatalf("Error reading the file: %v", err)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "policy-test" {
+		os.Exit(runPolicyTest(os.Args[2:]))
 	}
-	jsonData := string(content)
-
-	result := gjson.Parse(jsonData)
-	input := gjsonToResultMap(result)
 
 	logger.SetFlags(logger.Lshortfile)
 
-	opts, err := loadLegacyOptions(input)
-
+	opts, err := loadConfig(configFile)
 	if err != nil {
 		logger.Fatalf("ERROR: %v", err)
 	}
 
-	if err = validation.Validate(opts); err != nil {
-		logger.Fatalf("%s", err)
-	}
-
 	validator := NewValidator(opts.EmailDomains, opts.AuthenticatedEmailsFile)
 	oauthproxy, err := NewOAuthProxy(opts, validator)
 	if err != nil {
 		logger.Fatalf("ERROR: Failed to initialise OAuth2 Proxy: %v", err)
 	}
 
+	if opts.MetricsAddress != "" {
+		go func() {
+			logger.Printf("Serving metrics on %s", opts.MetricsAddress)
+			if err := http.ListenAndServe(opts.MetricsAddress, metrics.Handler()); err != nil {
+				logger.Fatalf("ERROR: metrics server: %v", err)
+			}
+		}()
+	}
+
+	if opts.ExtAuthzAddress != "" {
+		go func() {
+			logger.Printf("Serving Envoy ext_authz on %s", opts.ExtAuthzAddress)
+			if err := serveExtAuthz(opts.ExtAuthzAddress, oauthproxy); err != nil {
+				logger.Fatalf("ERROR: ext_authz server: %v", err)
+			}
+		}()
+	}
+
 	//r := httptest.NewRequest(http.MethodGet, "/get", nil)
 	//
 	//w := httptest.NewRecorder()
@@ -54,6 +64,28 @@ func main() {
 	}
 }
 
+// loadConfig reads, converts and validates the JSON configuration file
+func loadConfig(path string) (*options.Options, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the file: %v", err)
+	}
+	jsonData := string(content)
+
+	result := gjson.Parse(jsonData)
+	input := gjsonToResultMap(result)
+
+	opts, err := loadLegacyOptions(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = validation.Validate(opts); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
 // loadLegacyOptions loads the old toml options using the legacy flag set
 // and legacy options struct.
 func loadLegacyOptions(input map[string]interface{}) (*options.Options, error) {
@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -10,10 +10,14 @@ import (
 	"strings"
 	"time"
 
+	ipapi "oidc/pkg/apis/ip"
 	middlewareapi "oidc/pkg/apis/middleware"
 	"oidc/pkg/apis/options"
 	sessionsapi "oidc/pkg/apis/sessions"
 	"oidc/pkg/cookies"
+	"oidc/pkg/encryption"
+	"oidc/pkg/ip"
+	"oidc/pkg/metrics"
 	"oidc/pkg/middleware"
 	"oidc/pkg/sessions"
 	"oidc/providers"
@@ -21,7 +25,6 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/justinas/alice"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/app/redirect"
-	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/encryption"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
 )
@@ -31,8 +34,17 @@ const (
 	schemeHTTPS     = "https"
 	applicationJSON = "application/json"
 
-	oauthStartPath    = "/start"
-	oauthCallbackPath = "/callback"
+	signOutPath           = "/sign_out"
+	userInfoPath          = "/userinfo"
+	sessionInfoPath       = "/session"
+	tokenPath             = "/token"
+	silentPath            = "/silent"
+	oauthStartPath        = "/start"
+	oauthCallbackPath     = "/callback"
+	backChannelLogoutPath = "/backchannel-logout"
+
+	// Maximum time allowed for revoking a session's tokens at the provider
+	tokenRevocationTimeout = 10 * time.Second
 )
 
 var (
@@ -55,6 +67,19 @@ type OAuthProxy struct {
 	sessionStore        sessionsapi.SessionStore
 	ProxyPrefix         string
 	skipAuthPreflight   bool
+	realClientIPParser  ipapi.RealClientIPParser
+
+	sessionInfoClaims        []string
+	sessionInfoIncludeTokens bool
+
+	bffOptions options.BFFOptions
+
+	deviceFlow           bool
+	deviceFlowResponse   string
+	deviceAuthorizations *deviceAuthorizations
+
+	adminOptions options.AdminOptions
+	adminGroups  map[string]struct{}
 
 	sessionChain alice.Chain
 	preAuthChain alice.Chain
@@ -63,7 +88,28 @@ type OAuthProxy struct {
 	redirectValidator redirect.Validator
 	appDirector       redirect.AppDirector
 
-	encodeState bool
+	stateCipher encryption.Cipher
+	silentSSO   bool
+
+	csrfCookieOptions *options.Cookie
+	formPostCSRF      string
+
+	preserveRequest options.PreserveRequestOptions
+
+	protectedResource *url.URL
+	issuerURL         string
+
+	stepUpRoutes []stepUpRoute
+
+	tokenExchangeRoutes []tokenExchangeRoute
+	exchangedTokens     *exchangedTokens
+
+	dpopRoutes []dpopRoute
+
+	policyRoutes []policyRoute
+	authzCallout *authzCallout
+
+	kubernetesUpstream *kubernetesUpstream
 }
 
 // NewOAuthProxy creates a new instance of OAuthProxy from the options provided
@@ -73,6 +119,10 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		return nil, fmt.Errorf("error initialising session store: %v", err)
 	}
 
+	if _, ok := sessionStore.(sessionsapi.SessionIndex); opts.Admin.Enabled() && !ok {
+		return nil, errors.New("the session administration API requires a server side session store")
+	}
+
 	provider, err := providers.NewProvider(opts.Providers[0])
 	if err != nil {
 		return nil, fmt.Errorf("error initialising provider: %v", err)
@@ -84,9 +134,19 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 	}
 
 	logger.Printf("OAuthProxy configured for %s Client ID: %s", provider.Data().ProviderName, opts.Providers[0].ClientID)
-	refresh := "disabled"
+	var refreshTriggers []string
 	if opts.Cookie.Refresh != time.Duration(0) {
-		refresh = fmt.Sprintf("after %s", opts.Cookie.Refresh)
+		refreshTriggers = append(refreshTriggers, fmt.Sprintf("after %s", opts.Cookie.Refresh))
+	}
+	if opts.Session.RefreshBeforeExpiry != time.Duration(0) {
+		refreshTriggers = append(refreshTriggers, fmt.Sprintf("%s before expiry", opts.Session.RefreshBeforeExpiry))
+	}
+	refresh := "disabled"
+	if len(refreshTriggers) > 0 {
+		refresh = strings.Join(refreshTriggers, " or ")
+		if opts.Session.BackgroundRefresh {
+			refresh += " in background"
+		}
 	}
 
 	logger.Printf("Cookie settings: name:%s secure(https):%v httponly:%v expiry:%s domains:%s path:%s samesite:%s refresh:%s", opts.Cookie.Name, opts.Cookie.Secure, opts.Cookie.HTTPOnly, opts.Cookie.Expire, strings.Join(opts.Cookie.Domains, ","), opts.Cookie.Path, opts.Cookie.SameSite, refresh)
@@ -97,6 +157,31 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 	}
 	sessionChain := buildSessionChain(opts, provider, sessionStore)
 
+	stepUpRoutes, err := buildStepUpRoutes(opts)
+	if err != nil {
+		return nil, err
+	}
+	tokenExchangeRoutes, err := buildTokenExchangeRoutes(opts)
+	if err != nil {
+		return nil, err
+	}
+	dpopRoutes, err := buildDPoPRoutes(opts)
+	if err != nil {
+		return nil, err
+	}
+	policyRoutes, err := buildPolicyRoutes(opts)
+	if err != nil {
+		return nil, err
+	}
+	kubernetesUpstream, err := newKubernetesUpstream(opts.Kubernetes, opts.Cookie.Name)
+	if err != nil {
+		return nil, err
+	}
+	stateCipher, err := encryption.NewGCMCipher(encryption.SecretBytes(opts.Cookie.Secret))
+	if err != nil {
+		return nil, fmt.Errorf("error creating OAuth state cipher: %v", err)
+	}
+
 	redirectValidator := redirect.NewValidator(opts.WhitelistDomains)
 	appDirector := redirect.NewAppDirector(redirect.AppDirectorOpts{
 		ProxyPrefix: opts.ProxyPrefix,
@@ -114,13 +199,55 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		relativeRedirectURL: opts.RelativeRedirectURL,
 		whitelistDomains:    opts.WhitelistDomains,
 		skipAuthPreflight:   opts.SkipAuthPreflight,
+		realClientIPParser:  opts.GetRealClientIPParser(),
+
+		sessionInfoClaims:        opts.SessionInfoClaims,
+		sessionInfoIncludeTokens: opts.SessionInfoIncludeTokens,
+
+		bffOptions: opts.BFF,
+
+		deviceFlow:           opts.DeviceFlow.Enabled,
+		deviceFlowResponse:   opts.DeviceFlow.Response,
+		deviceAuthorizations: newDeviceAuthorizations(opts.DeviceFlow.MaxPending, opts.DeviceFlow.MaxPendingPerClient),
+
+		adminOptions: opts.Admin,
+		adminGroups:  make(map[string]struct{}, len(opts.Admin.Groups)),
 
 		sessionChain: sessionChain,
 		preAuthChain: preAuthChain,
 
 		redirectValidator: redirectValidator,
 		appDirector:       appDirector,
-		encodeState:       opts.EncodeState,
+		stateCipher:       stateCipher,
+		silentSSO:         opts.SilentSSO,
+
+		csrfCookieOptions: buildCSRFCookieOptions(opts),
+		formPostCSRF:      opts.FormPostCSRF,
+
+		preserveRequest: opts.PreserveRequest,
+
+		issuerURL: opts.Providers[0].OIDCConfig.IssuerURL,
+
+		stepUpRoutes: stepUpRoutes,
+
+		tokenExchangeRoutes: tokenExchangeRoutes,
+		exchangedTokens:     newExchangedTokens(),
+
+		dpopRoutes: dpopRoutes,
+
+		policyRoutes: policyRoutes,
+		authzCallout: newAuthzCallout(opts.AuthzCallout),
+
+		kubernetesUpstream: kubernetesUpstream,
+	}
+	if opts.ProtectedResource != "" {
+		p.protectedResource, err = url.Parse(opts.ProtectedResource)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protected resource: %v", err)
+		}
+	}
+	for _, group := range opts.Admin.Groups {
+		p.adminGroups[group] = struct{}{}
 	}
 	p.buildServeMux(opts.ProxyPrefix)
 
@@ -138,6 +265,8 @@ func (p *OAuthProxy) buildServeMux(proxyPrefix string) {
 	// are not applied.
 	p.buildProxySubRouter(r.PathPrefix(proxyPrefix).Subrouter())
 
+	r.Path(p.protectedResourceMetadataRoute()).Methods(http.MethodGet).HandlerFunc(p.ProtectedResourceMetadata)
+
 	// Register serveHTTP last, so it catches anything that isn't already caught earlier.
 	// Anything that got to this point needs to have a session loaded.
 	r.PathPrefix("/").Handler(p.sessionChain.ThenFunc(p.Proxy))
@@ -147,8 +276,29 @@ func (p *OAuthProxy) buildServeMux(proxyPrefix string) {
 func (p *OAuthProxy) buildProxySubRouter(s *mux.Router) {
 	s.Use(prepareNoCacheMiddleware)
 
+	s.Path(signOutPath).HandlerFunc(p.SignOut)
 	s.Path(oauthStartPath).HandlerFunc(p.OAuthStart)
 	s.Path(oauthCallbackPath).HandlerFunc(p.OAuthCallback)
+	s.Path(backChannelLogoutPath).Methods(http.MethodPost).HandlerFunc(p.BackChannelLogout)
+
+	// The session info endpoints need the session loaded
+	s.Path(userInfoPath).Methods(http.MethodGet).Handler(p.sessionChain.ThenFunc(p.UserInfo))
+	s.Path(sessionInfoPath).Methods(http.MethodGet).Handler(p.sessionChain.ThenFunc(p.SessionInfo))
+	if p.silentSSO {
+		s.Path(silentPath).Methods(http.MethodGet).Handler(p.sessionChain.ThenFunc(p.Silent))
+	}
+	if p.bffOptions.TokenEndpoint {
+		s.Path(tokenPath).Methods(http.MethodPost).Handler(p.sessionChain.ThenFunc(p.Token))
+	}
+
+	if p.deviceFlow {
+		s.Path(deviceAuthorizePath).Methods(http.MethodPost).HandlerFunc(p.DeviceAuthorize)
+		s.Path(deviceTokenPath).Methods(http.MethodPost).HandlerFunc(p.DeviceToken)
+	}
+
+	if p.adminOptions.Enabled() {
+		p.buildAdminRoutes(s)
+	}
 }
 
 // buildPreAuthChain constructs a chain that should process every request before
@@ -162,24 +312,73 @@ func buildPreAuthChain(opts *options.Options) (alice.Chain, error) {
 func buildSessionChain(opts *options.Options, provider providers.Provider, sessionStore sessionsapi.SessionStore) alice.Chain {
 	chain := alice.New()
 
+	if opts.BearerTokenIntrospection {
+		chain = chain.Append(middleware.NewBearerSessionLoader(provider.CreateSessionFromIntrospection))
+	}
+
 	chain = chain.Append(middleware.NewStoredSessionLoader(&middleware.StoredSessionLoaderOptions{
-		SessionStore:    sessionStore,
-		RefreshPeriod:   opts.Cookie.Refresh,
-		RefreshSession:  provider.RefreshSession,
-		ValidateSession: provider.ValidateSession,
+		SessionStore:        sessionStore,
+		RefreshPeriod:       opts.Cookie.Refresh,
+		RefreshBeforeExpiry: opts.Session.RefreshBeforeExpiry,
+		BackgroundRefresh:   opts.Session.BackgroundRefresh,
+		IdleTimeout:         opts.Session.IdleTimeout,
+		MaxLifetime:         opts.Session.MaxLifetime,
+		RefreshSession:      provider.RefreshSession,
+		ValidateSession:     provider.ValidateSession,
 	}))
 
 	return chain
 }
 
+// SignOut sends a response to clear the authentication cookie and revokes
+// the session's tokens at the provider
+func (p *OAuthProxy) SignOut(rw http.ResponseWriter, req *http.Request) {
+	redirect, err := p.appDirector.GetRedirect(req)
+	if err != nil {
+		logger.Errorf("Error obtaining redirect: %v", err)
+		// p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Load the session before clearing it so its tokens can be revoked
+	session, err := p.sessionStore.Load(req)
+	if err != nil && !errors.Is(err, http.ErrNoCookie) {
+		logger.Errorf("Error loading session to sign out: %v", err)
+	}
+
+	err = p.ClearSessionCookie(rw, req)
+	if err != nil {
+		logger.Errorf("Error clearing session cookie: %v", err)
+		// p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p.revokeSession(session)
+
+	http.Redirect(rw, req, redirect, http.StatusFound)
+}
+
 // OAuthStart starts the OAuth2 authentication flow
 func (p *OAuthProxy) OAuthStart(rw http.ResponseWriter, req *http.Request) {
+	if p.showSessionEnded(rw, req) {
+		return
+	}
 	// start the flow permitting login URL query parameters to be overridden from the request URL
-	p.doOAuthStart(rw, req, req.URL.Query())
+	var required url.Values
+	if p.silentSSO && req.URL.Query().Has(silentStartParam) {
+		required = url.Values{"prompt": {"none"}}
+	}
+	p.doOAuthStart(rw, req, req.URL.Query(), required)
 }
 
-func (p *OAuthProxy) doOAuthStart(rw http.ResponseWriter, req *http.Request, overrides url.Values) {
+// doOAuthStart redirects the user to the provider's login URL. Overrides are
+// only applied where the login URL parameter configuration allows them,
+// while required parameters are always set, for example to step up the
+// authentication of an existing session.
+func (p *OAuthProxy) doOAuthStart(rw http.ResponseWriter, req *http.Request, overrides url.Values, required url.Values) {
 	extraParams := p.provider.Data().LoginURLParams(overrides)
+	for param, values := range required {
+		extraParams[param] = values
+	}
 	prepareNoCache(rw)
 
 	var (
@@ -206,7 +405,7 @@ func (p *OAuthProxy) doOAuthStart(rw http.ResponseWriter, req *http.Request, ove
 		extraParams.Add("code_challenge_method", codeChallengeMethod)
 	}
 
-	csrf, err := cookies.NewCSRF(p.CookieOptions, codeVerifier)
+	csrf, err := cookies.NewCSRF(p.csrfCookieOptions, codeVerifier)
 	if err != nil {
 		logger.Errorf("Error creating CSRF nonce: %v", err)
 		// p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
@@ -221,12 +420,29 @@ func (p *OAuthProxy) doOAuthStart(rw http.ResponseWriter, req *http.Request, ove
 	}
 
 	callbackRedirect := p.getOAuthRedirectURI(req)
-	loginURL := p.provider.GetLoginURL(
+	state, err := p.encodeState(csrf.HashOAuthState(), appRedirect)
+	if err != nil {
+		logger.Errorf("Error encoding OAuth2 state: %v", err)
+		// p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
+		return
+	}
+	loginURL, err := p.provider.GetLoginURL(
 		callbackRedirect,
-		encodeState(csrf.HashOAuthState(), appRedirect, p.encodeState),
+		state,
 		csrf.HashOIDCNonce(),
 		extraParams,
 	)
+	if err != nil {
+		logger.Errorf("Error creating login URL: %v", err)
+		// p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
+		return
+	}
+	loginURL, err = p.provider.PushAuthorizationRequest(req.Context(), loginURL)
+	if err != nil {
+		logger.Errorf("Error pushing authorization request: %v", err)
+		// p.ErrorPage(rw, req, http.StatusBadGateway, err.Error())
+		return
+	}
 
 	if _, err := csrf.SetCookie(rw, req); err != nil {
 		logger.Errorf("Error setting CSRF cookie: %v", err)
@@ -248,8 +464,20 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		// p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 		return
 	}
+	if p.resubmitFormPost(rw, req) {
+		return
+	}
+	req.Form, err = p.provider.DecodeAuthorizationResponse(req.Context(), req.Form)
+	if err != nil {
+		logger.PrintAuthf("", req, logger.AuthFailure, "Invalid authentication via OAuth2: %v", err)
+		// p.ErrorPage(rw, req, http.StatusForbidden, err.Error())
+		return
+	}
 	errorString := req.Form.Get("error")
 	if errorString != "" {
+		if p.handleSilentAuthError(rw, req, errorString) {
+			return
+		}
 		logger.Errorf("Error while parsing OAuth2 callback: %s", errorString)
 		// message := fmt.Sprintf("Login Failed: The upstream identity provider returned an error: %s", errorString)
 		// Set the debug message and override the non debug message to be the same for this case
@@ -257,7 +485,14 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	csrf, err := cookies.LoadCSRFCookie(req, p.CookieOptions)
+	nonce, appRedirect, err := p.decodeState(req.Form.Get("state"))
+	if err != nil {
+		logger.PrintAuthf("", req, logger.AuthFailure, "Invalid authentication via OAuth2: %v", err)
+		// p.ErrorPage(rw, req, http.StatusForbidden, err.Error(), "Login Failed: The login request is invalid or has expired. Please try again.")
+		return
+	}
+
+	csrf, err := cookies.LoadCSRFCookie(req, p.csrfCookieOptions, nonce)
 	if err != nil {
 		logger.Println(req, logger.AuthFailure, "Invalid authentication via OAuth2. Error while loading CSRF cookie:", err.Error())
 		// p.ErrorPage(rw, req, http.StatusForbidden, err.Error(), "Login Failed: Unable to find a valid CSRF token. Please try again.")
@@ -280,13 +515,6 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 
 	csrf.ClearCookie(rw, req)
 
-	nonce, appRedirect, err := decodeState(req.Form.Get("state"), p.encodeState)
-	if err != nil {
-		logger.Errorf("Error while parsing OAuth2 state: %v", err)
-		// p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
-		return
-	}
-
 	if !csrf.CheckOAuthState(nonce) {
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: CSRF token mismatch, potential attack")
 		// p.ErrorPage(rw, req, http.StatusForbidden, "CSRF token mismatch, potential attack", "Login Failed: Unable to find a valid CSRF token. Please try again.")
@@ -317,6 +545,9 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 			// p.ErrorPage(rw, req, http.StatusInternalServerError, err.Error())
 			return
 		}
+		if p.replayPostBody(rw, req, appRedirect) {
+			return
+		}
 		http.Redirect(rw, req, appRedirect, http.StatusFound)
 	} else {
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: unauthorized")
@@ -324,6 +555,53 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// BackChannelLogout receives OpenID Connect Back-Channel Logout 1.0 requests
+// from the IdP. Sessions created within the logout token's `sid` are removed
+// from the session store, or every session of the `sub` when no `sid` is given.
+func (p *OAuthProxy) BackChannelLogout(rw http.ResponseWriter, req *http.Request) {
+	index, ok := p.sessionStore.(sessionsapi.SessionIndex)
+	if !ok {
+		logger.Errorf("Back-channel logout requires a server side session store")
+		writeOAuthError(rw, http.StatusNotImplemented, "invalid_request", "back-channel logout is not supported by the session store")
+		return
+	}
+
+	err := req.ParseForm()
+	if err != nil {
+		logger.Errorf("Error while parsing back-channel logout request: %v", err)
+		writeOAuthError(rw, http.StatusBadRequest, "invalid_request", "unable to parse request body")
+		return
+	}
+
+	rawToken := req.PostForm.Get("logout_token")
+	if rawToken == "" {
+		writeOAuthError(rw, http.StatusBadRequest, "invalid_request", "missing logout_token")
+		return
+	}
+
+	token, err := p.provider.Data().VerifyLogoutToken(req.Context(), rawToken)
+	if err != nil {
+		logger.Errorf("Invalid back-channel logout token: %v", err)
+		writeOAuthError(rw, http.StatusBadRequest, "invalid_request", "invalid logout_token")
+		return
+	}
+
+	var cleared int
+	if token.SessionID != "" {
+		cleared, err = index.ClearBySessionID(req.Context(), token.SessionID)
+	} else {
+		cleared, err = index.ClearBySubject(req.Context(), token.Subject)
+	}
+	if err != nil {
+		logger.Errorf("Error clearing sessions during back-channel logout: %v", err)
+		writeOAuthError(rw, http.StatusInternalServerError, "server_error", "unable to clear sessions")
+		return
+	}
+
+	logger.Printf("Back-channel logout for sub:%q sid:%q removed %d session(s)", token.Subject, token.SessionID, cleared)
+	rw.WriteHeader(http.StatusOK)
+}
+
 // Proxy proxies the user request if the user is authenticated else it prompts
 // them to authenticate
 func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
@@ -331,22 +609,53 @@ func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 	switch {
 	case err == nil:
 		// we are authenticated
-		p.addHeadersForProxying(rw, session)
+		if !p.prepareUpstream(rw, req, session) {
+			return
+		}
+		if p.kubernetesUpstream != nil {
+			p.kubernetesUpstream.serve(rw, req, session, p.upstreamHeaders())
+			return
+		}
 		//TODO：check correct？
 	case errors.Is(err, ErrNeedsLogin):
 		// we need to send the user to a login screen
-		if isAjax(req) {
+		if isAjax(req) || middlewareapi.GetRequestScope(req).BearerError != "" {
 			logger.Printf("No valid authentication in request. Access Denied.")
-			// no point redirecting an AJAX request
+			// no point redirecting an AJAX request or API client
+			p.writeLoginRequired(rw, req)
+			return
+		}
+		p.preservePostBody(rw, req)
+		if reason := middlewareapi.GetRequestScope(req).SessionEndReason; reason != "" {
+			// send the user through the start endpoint, which shows them why
+			// their session ended before they sign in again
+			logger.Printf("Session ended (%s). Redirecting to login.", reason)
+			if p.startLoginWithFragment(rw, req, url.Values{sessionEndReasonParam: {reason}}) {
+				return
+			}
+			http.Redirect(rw, req, p.loginURL(req), http.StatusFound)
+			return
+		}
+		if p.silentSSO {
+			logger.Printf("No valid authentication in request. Attempting silent login.")
+			if p.startLoginWithFragment(rw, req, url.Values{silentStartParam: {"1"}}) {
+				return
+			}
+			p.doOAuthStart(rw, req, nil, url.Values{"prompt": {"none"}})
 			return
 		}
 		logger.Printf("No valid authentication in request. Initiating login.")
+		if p.startLoginWithFragment(rw, req, nil) {
+			return
+		}
 		// start OAuth flow, but only with the default login URL params - do not
 		// consider this request's query params as potential overrides, since
 		// the user did not explicitly start the login flow
-		p.doOAuthStart(rw, req, nil)
+		p.doOAuthStart(rw, req, nil, nil)
 	case errors.Is(err, ErrAccessDenied):
-		// p.ErrorPage(rw, req, http.StatusForbidden, "The session failed authorization checks")
+		// Browsers get the 403 too: an empty response would tell the
+		// auth request front end to allow the request
+		p.writeAccessDenied(rw, req)
 	default:
 		// unknown error
 		logger.Errorf("Unexpected internal error: %v", err)
@@ -354,6 +663,33 @@ func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// prepareUpstream runs the checks that apply to authenticated requests and
+// sets the headers passed to the upstream. If a check fails, a response has
+// been written and false is returned.
+func (p *OAuthProxy) prepareUpstream(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) bool {
+	// Bearer tokens aren't sent by browsers on their own, so only cookie
+	// sessions need the anti-CSRF header
+	bearer := middlewareapi.GetRequestScope(req).BearerSession
+	if session != nil && !bearer && p.bffOptions.EnforceCSRFHeader && isStateChanging(req.Method) {
+		if err := p.checkAntiCSRF(req); err != nil {
+			logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Rejected cookie authenticated request: %v", err)
+			writeOAuthError(rw, http.StatusForbidden, "forbidden", err.Error())
+			return false
+		}
+	}
+	if session != nil && !p.stepUpIfRequired(rw, req, session) {
+		return false
+	}
+	if session != nil && !p.addUpstreamAuthorization(rw, req, session) {
+		return false
+	}
+	if session != nil && !p.addUpstreamDPoP(rw, req, session) {
+		return false
+	}
+	p.addHeadersForProxying(rw, session)
+	return true
+}
+
 // getAuthenticatedSession checks whether a user is authenticated and returns a session object and nil error if so
 // Returns:
 // - `nil, ErrNeedsLogin` if user needs to log in.
@@ -383,12 +719,30 @@ func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.R
 			cause = "invalid email"
 		}
 
+		if middlewareapi.GetRequestScope(req).BearerSession {
+			// The token belongs to the API client, there is nothing to remove
+			logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authorization via bearer token (%s): %s", cause, session)
+			return nil, ErrAccessDenied
+		}
+
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authorization via session (%s): removing session %s", cause, session)
 		// Invalid session, clear it
 		err := p.ClearSessionCookie(rw, req)
 		if err != nil {
 			logger.Errorf("Error clearing session cookie: %v", err)
 		}
+		p.revokeSession(session)
+		return nil, ErrAccessDenied
+	}
+
+	// Policies are per route, so a denial leaves the session in place
+	if route, reason := p.checkPolicy(req, session); reason != "" {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Denied by policy route %q (%s %s): %s", route.name, req.Method, requestPath(req), reason)
+		return nil, ErrAccessDenied
+	}
+
+	if reason := p.checkAuthzCallout(rw, req, session); reason != "" {
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Denied by authorization service (%s %s): %s", req.Method, requestPath(req), reason)
 		return nil, ErrAccessDenied
 	}
 
@@ -423,32 +777,6 @@ func prepareNoCacheMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// encodedState builds the OAuth state param out of our nonce and
-// original application redirect
-func encodeState(nonce string, redirect string, encode bool) string {
-	rawString := fmt.Sprintf("%v:%v", nonce, redirect)
-	if encode {
-		return base64.RawURLEncoding.EncodeToString([]byte(rawString))
-	}
-	return rawString
-}
-
-// decodeState splits the reflected OAuth state response back into
-// the nonce and original application redirect
-func decodeState(state string, encode bool) (string, string, error) {
-	toParse := state
-	if encode {
-		decoded, _ := base64.RawURLEncoding.DecodeString(state)
-		toParse = string(decoded)
-	}
-
-	parsedState := strings.SplitN(toParse, ":", 2)
-	if len(parsedState) != 2 {
-		return "", "", errors.New("invalid length")
-	}
-	return parsedState[0], parsedState[1], nil
-}
-
 // getOAuthRedirectURI returns the redirectURL that the upstream OAuth Provider will
 // redirect clients to once authenticated.
 // This is usually the OAuthProxy callback URL.
@@ -487,6 +815,32 @@ func (p *OAuthProxy) ClearSessionCookie(rw http.ResponseWriter, req *http.Reques
 	return p.sessionStore.Clear(rw, req)
 }
 
+// revokeSession revokes the session's refresh and access tokens at the
+// provider in the background. Revocation is best-effort: failures are logged
+// and counted but never delay or fail the request that cleared the session.
+func (p *OAuthProxy) revokeSession(session *sessionsapi.SessionState) {
+	if session == nil || (session.AccessToken == "" && session.RefreshToken == "") {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tokenRevocationTimeout)
+		defer cancel()
+
+		err := p.provider.RevokeSession(ctx, session)
+		switch {
+		case err == nil:
+			metrics.TokenRevocations.Add("success", 1)
+			logger.Printf("Revoked tokens for session %s", session)
+		case errors.Is(err, providers.ErrNotImplemented):
+			// No revocation endpoint configured or discovered
+		default:
+			metrics.TokenRevocations.Add("failure", 1)
+			logger.Errorf("Error revoking tokens for session %s: %v", session, err)
+		}
+	}()
+}
+
 // addHeadersForProxying adds the appropriate headers the request / response for proxying
 func (p *OAuthProxy) addHeadersForProxying(rw http.ResponseWriter, session *sessionsapi.SessionState) {
 	if session == nil {
@@ -499,6 +853,20 @@ func (p *OAuthProxy) addHeadersForProxying(rw http.ResponseWriter, session *sess
 	}
 } // TODO: check if this is still needed
 
+// proxyUpstreamHeaders are the headers the proxy itself sets for the upstream
+var proxyUpstreamHeaders = []string{"GAP-Auth", "Authorization", "DPoP"}
+
+// upstreamHeaders returns the name of every header the proxy can set for the
+// upstream, including those the authorization service may return. Other
+// headers on the response, such as the no-cache ones, are for the client.
+func (p *OAuthProxy) upstreamHeaders() []string {
+	names := append([]string{}, proxyUpstreamHeaders...)
+	if p.authzCallout != nil {
+		names = append(names, p.authzCallout.headers...)
+	}
+	return names
+}
+
 func (p *OAuthProxy) redeemCode(req *http.Request, codeVerifier string) (*sessionsapi.SessionState, error) {
 	code := req.Form.Get("code")
 	if code == "" {
@@ -511,15 +879,24 @@ func (p *OAuthProxy) redeemCode(req *http.Request, codeVerifier string) (*sessio
 		return nil, err
 	}
 
+	p.initSession(req, s)
+	return s, nil
+}
+
+// initSession fills in the details of a newly authenticated session
+func (p *OAuthProxy) initSession(req *http.Request, s *sessionsapi.SessionState) {
 	// Force setting these in case the Provider didn't
 	if s.CreatedAt == nil {
 		s.CreatedAtNow()
 	}
+	s.AuthenticatedAt = s.CreatedAt
+	s.LastSeenAtNow()
 	if s.ExpiresOn == nil {
 		s.ExpiresIn(p.CookieOptions.Expire)
 	}
 
-	return s, nil
+	s.ProviderID = p.provider.Data().ProviderID
+	s.ClientIP = ip.GetClientString(p.realClientIPParser, req, false)
 }
 
 func (p *OAuthProxy) enrichSessionState(ctx context.Context, s *sessionsapi.SessionState) error {
@@ -536,6 +913,23 @@ func (p *OAuthProxy) enrichSessionState(ctx context.Context, s *sessionsapi.Sess
 	return p.provider.EnrichSession(ctx, s)
 }
 
+// writeJSON writes v as the JSON body of the response with the given status code
+func writeJSON(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Content-Type", applicationJSON)
+	rw.WriteHeader(code)
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		logger.Errorf("Error encoding JSON response: %v", err)
+	}
+}
+
+// writeOAuthError writes an OAuth 2.0 style error response (RFC 6749 section 5.2)
+func writeOAuthError(rw http.ResponseWriter, code int, errorCode, description string) {
+	writeJSON(rw, code, map[string]string{
+		"error":             errorCode,
+		"error_description": description,
+	})
+}
+
 // isAjax checks if a request is an ajax request
 func isAjax(req *http.Request) bool {
 	acceptValues := req.Header.Values("Accept")
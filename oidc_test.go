@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	sessionsapi "oidc/pkg/apis/sessions"
+	"oidc/pkg/validation"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+const (
+	testClientID     = "proxy"
+	testCookieSecret = "0123456789abcdef0123456789abcdef"
+	testKeyID        = "test"
+)
+
+// newTestProxy builds a proxy from the configuration the way loadConfig does,
+// with settings for a provider that is never contacted unless config points
+// the provider endpoints somewhere else
+func newTestProxy(t *testing.T, config map[string]interface{}) *OAuthProxy {
+	t.Helper()
+
+	input := map[string]interface{}{
+		"provider":            "oidc",
+		"client_id":           testClientID,
+		"client_secret":       "secret",
+		"cookie_secret":       testCookieSecret,
+		"email_domains":       "*",
+		"oidc_issuer_url":     "https://idp.example.com",
+		"skip_oidc_discovery": true,
+		"login_url":           "https://idp.example.com/auth",
+		"redeem_url":          "https://idp.example.com/token",
+		"oidc_jwks_url":       "https://idp.example.com/jwks",
+	}
+	for key, value := range config {
+		input[key] = value
+	}
+
+	opts, err := loadLegacyOptions(input)
+	if err != nil {
+		t.Fatalf("loading options: %v", err)
+	}
+	if err := validation.Validate(opts); err != nil {
+		t.Fatalf("validating options: %v", err)
+	}
+	p, err := NewOAuthProxy(opts, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("creating proxy: %v", err)
+	}
+	return p
+}
+
+// testIdP stands in for an OIDC provider. It signs ID tokens, answers the
+// device authorization and token endpoints, and reports the first device
+// code polls as pending.
+type testIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu           sync.Mutex
+	pendingPolls int
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	idp := &testIdP{key: key, pendingPolls: 1}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jwks", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: testKeyID, Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/device", func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, map[string]interface{}{
+			"device_code":      "device-code",
+			"user_code":        "USER-CODE",
+			"verification_uri": idp.URL + "/verify",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("/token", func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			writeOAuthError(rw, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		if req.PostForm.Get("device_code") != "device-code" {
+			writeOAuthError(rw, http.StatusBadRequest, "invalid_grant", "unknown device code")
+			return
+		}
+		idp.mu.Lock()
+		pending := idp.pendingPolls > 0
+		idp.pendingPolls--
+		idp.mu.Unlock()
+		if pending {
+			writeOAuthError(rw, http.StatusBadRequest, "authorization_pending", "")
+			return
+		}
+		writeJSON(rw, http.StatusOK, map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idp.idToken(t, map[string]interface{}{"sub": "user-1", "email": "user@example.com"}),
+		})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// config returns the provider settings pointing the proxy at the IdP
+func (i *testIdP) config(config map[string]interface{}) map[string]interface{} {
+	settings := map[string]interface{}{
+		"oidc_issuer_url":          i.URL,
+		"login_url":                i.URL + "/auth",
+		"redeem_url":               i.URL + "/token",
+		"oidc_jwks_url":            i.URL + "/jwks",
+		"device_authorization_url": i.URL + "/device",
+	}
+	for key, value := range config {
+		settings[key] = value
+	}
+	return settings
+}
+
+// idToken signs an ID token for the proxy with the given claims
+func (i *testIdP) idToken(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: i.key, KeyID: testKeyID}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		t.Fatalf("creating signer: %v", err)
+	}
+	now := time.Now()
+	token := map[string]interface{}{
+		"iss": i.URL,
+		"aud": testClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for key, value := range claims {
+		token[key] = value
+	}
+	raw, err := jwt.Signed(signer).Claims(token).CompactSerialize()
+	if err != nil {
+		t.Fatalf("signing ID token: %v", err)
+	}
+	return raw
+}
+
+// decodeJSON decodes the recorded response body into v
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+}
+
+// sessionCookie saves the session the way the proxy does after a login and
+// returns the Cookie header a browser would send with it
+func sessionCookie(t *testing.T, p *OAuthProxy, session *sessionsapi.SessionState) string {
+	t.Helper()
+
+	session.CreatedAtNow()
+	session.SetExpiresOn(time.Now().Add(time.Hour))
+	rec := httptest.NewRecorder()
+	if err := p.SaveSession(rec, httptest.NewRequest(http.MethodGet, "/", nil), session); err != nil {
+		t.Fatalf("saving session: %v", err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("saving session: no cookie set")
+	}
+	var header string
+	for _, c := range cookies {
+		if header != "" {
+			header += "; "
+		}
+		header += c.Name + "=" + c.Value
+	}
+	return header
+}
+
+// serve sends a browser request with the cookie to the proxy
+func serve(p *OAuthProxy, method, target, cookie string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if cookie != "" {
+		req.Header.Set("Cookie", cookie)
+	}
+	req.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	p.serveMux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestProxyDeniesBrowserRequestsFailingAPolicy(t *testing.T) {
+	p := newTestProxy(t, map[string]interface{}{
+		"policy_routes": []interface{}{
+			map[string]interface{}{"name": "admins", "path": "^/admin", "policy": `"admins" in groups`},
+		},
+	})
+	cookie := sessionCookie(t, p, &sessionsapi.SessionState{
+		User:   "user-1",
+		Email:  "user@example.com",
+		Groups: []string{"staff"},
+	})
+
+	if rec := serve(p, http.MethodGet, "/admin/users", cookie); rec.Code != http.StatusForbidden {
+		t.Errorf("request denied by the policy: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := serve(p, http.MethodGet, "/home", cookie); rec.Code < 200 || rec.Code > 299 {
+		t.Errorf("request without a policy: got %d, want 2xx", rec.Code)
+	}
+}
@@ -38,8 +38,29 @@ type RequestScope struct {
 	// SessionRevalidated indicates whether the session has been revalidated since
 	// it was loaded or not.
 	SessionRevalidated bool
+
+	// SessionEndReason explains why a session was removed while loading it,
+	// for example because it reached its idle timeout or maximum lifetime.
+	SessionEndReason string
+
+	// BearerSession indicates the session was created from a bearer token
+	// presented with the request rather than loaded from the session store.
+	BearerSession bool
+
+	// BearerError is set to BearerErrorInvalidToken or BearerErrorUnavailable
+	// when a bearer token presented with the request could not be turned into
+	// a session. The underlying error is only logged.
+	BearerError string
 }
 
+const (
+	// BearerErrorInvalidToken means the bearer token was rejected
+	BearerErrorInvalidToken = "invalid_token"
+	// BearerErrorUnavailable means the bearer token couldn't be checked
+	// because the provider failed
+	BearerErrorUnavailable = "temporarily_unavailable"
+)
+
 // GetRequestScope returns the current request scope from the given request
 func GetRequestScope(req *http.Request) *RequestScope {
 	scope := req.Context().Value(RequestScopeKey)
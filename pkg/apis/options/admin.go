@@ -0,0 +1,18 @@
+package options
+
+// AdminOptions contains configuration for the session administration API.
+// The API is only enabled when at least one way of authorizing admins is
+// configured.
+type AdminOptions struct {
+	// Groups lists the groups whose members may use the API with their
+	// session cookie
+	Groups []string `mapstructure:"admin_groups"`
+	// BearerToken is a static token that grants access to the API when sent
+	// as `Authorization: Bearer <token>`
+	BearerToken string `mapstructure:"admin_bearer_token"`
+}
+
+// Enabled reports whether the session administration API is configured
+func (a AdminOptions) Enabled() bool {
+	return len(a.Groups) > 0 || a.BearerToken != ""
+}
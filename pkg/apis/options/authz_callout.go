@@ -0,0 +1,38 @@
+package options
+
+import "time"
+
+// AuthzCalloutOptions contains configuration for asking an external service
+// whether an authenticated request is allowed. The callout is only made when
+// URL is set.
+type AuthzCalloutOptions struct {
+	// URL receives a JSON document describing the user and the request as
+	// a POST, and answers whether the request is allowed
+	URL string `mapstructure:"authz_callout_url"`
+	// Timeout bounds each call to the service
+	Timeout time.Duration `mapstructure:"authz_callout_timeout"`
+	// CacheTTL is how long a decision is reused for identical documents
+	// If zero, every request is sent to the service
+	CacheTTL time.Duration `mapstructure:"authz_callout_cache_ttl"`
+	// CacheSize bounds the number of decisions cached, the oldest are
+	// dropped first
+	CacheSize int `mapstructure:"authz_callout_cache_size"`
+	// Headers are the headers the service may set for the upstream. Others
+	// it returns are ignored, and the client's own values of these are
+	// removed from proxied requests.
+	Headers []string `mapstructure:"authz_callout_headers"`
+	// FailOpen allows requests when the service can't be reached or answers
+	// with an error, rather than denying them
+	FailOpen bool `mapstructure:"authz_callout_fail_open"`
+}
+
+func authzCalloutDefaults() AuthzCalloutOptions {
+	return AuthzCalloutOptions{
+		URL:       "",
+		Timeout:   time.Duration(2) * time.Second,
+		CacheTTL:  time.Duration(30) * time.Second,
+		CacheSize: 10000,
+		Headers:   nil,
+		FailOpen:  false,
+	}
+}
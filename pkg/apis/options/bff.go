@@ -0,0 +1,24 @@
+package options
+
+// BFFOptions contains configuration for the backend-for-frontend token
+// endpoint and the anti-CSRF header that protects it.
+type BFFOptions struct {
+	// TokenEndpoint enables the endpoint that returns the session's access
+	// token to same-origin JavaScript
+	TokenEndpoint bool `mapstructure:"bff_token_endpoint"`
+	// CSRFHeader is the custom request header that must be present on
+	// requests to the token endpoint. Browsers won't send custom headers
+	// cross-origin without a CORS preflight.
+	CSRFHeader string `mapstructure:"bff_csrf_header"`
+	// EnforceCSRFHeader requires the CSRFHeader on every state-changing
+	// proxied request that is authenticated by the session cookie
+	EnforceCSRFHeader bool `mapstructure:"bff_enforce_csrf_header"`
+}
+
+func bffDefaults() BFFOptions {
+	return BFFOptions{
+		TokenEndpoint:     false,
+		CSRFHeader:        "X-CSRF-Protection",
+		EnforceCSRFHeader: false,
+	}
+}
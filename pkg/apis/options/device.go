@@ -0,0 +1,34 @@
+package options
+
+// DeviceFlowResponseTicket returns the device flow session to the CLI as the
+// session cookie, DeviceFlowResponseBearer returns the session's tokens.
+const (
+	DeviceFlowResponseTicket = "ticket"
+	DeviceFlowResponseBearer = "bearer"
+)
+
+// DeviceFlowOptions contains configuration for the RFC 8628 device
+// authorization grant endpoints used by command line clients.
+type DeviceFlowOptions struct {
+	// Enabled registers the device authorization and token endpoints
+	Enabled bool `mapstructure:"device_flow"`
+	// Response is how the authenticated session is handed back to the
+	// client, either "ticket" or "bearer". "bearer" requires
+	// bearer_token_introspection, so the proxy accepts the access token.
+	Response string `mapstructure:"device_flow_response"`
+	// MaxPending bounds the device authorizations the proxy holds at once,
+	// whether it is still polling for them or they wait to be collected
+	MaxPending int `mapstructure:"device_flow_max_pending"`
+	// MaxPendingPerClient bounds the device authorizations held for a
+	// single client IP
+	MaxPendingPerClient int `mapstructure:"device_flow_max_pending_per_client"`
+}
+
+func deviceFlowDefaults() DeviceFlowOptions {
+	return DeviceFlowOptions{
+		Enabled:             false,
+		Response:            DeviceFlowResponseTicket,
+		MaxPending:          1000,
+		MaxPendingPerClient: 5,
+	}
+}
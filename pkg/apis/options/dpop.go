@@ -0,0 +1,19 @@
+package options
+
+// DPoPRoute passes the session's access token to the upstream serving
+// requests whose path matches Path with the RFC 9449 DPoP scheme, along with
+// a fresh DPoP proof for the request. It requires the provider's `dpop`
+// option so the token is bound to the proxy's key.
+//
+// The upstream's responses don't pass through the proxy, so proofs for it
+// never carry a server nonce: upstreams that require one with `DPoP-Nonce`
+// and `use_dpop_nonce` are not supported. Only the token endpoint's nonces
+// are used and retried with.
+type DPoPRoute struct {
+	// Path is a regular expression matched against the request path
+	Path string `mapstructure:"path"`
+	// URL is the base URL of the upstream, such as `https://api.example.com`.
+	// The proofs are bound with `htu` to it joined with the request path.
+	// If not set, the URL of the request is used
+	URL string `mapstructure:"url"`
+}
@@ -0,0 +1,46 @@
+package options
+
+// KubernetesOptions contains configuration for proxying authenticated
+// requests to a Kubernetes API server, or a dashboard in front of one. The
+// proxy authenticates to the upstream with its own credentials and
+// impersonates the session's user. The mode is only enabled when
+// UpstreamURL is set.
+type KubernetesOptions struct {
+	// UpstreamURL is the URL of the API server requests are proxied to
+	UpstreamURL string `mapstructure:"kubernetes_upstream_url"`
+	// TokenFile holds the service account token sent to the upstream as a
+	// bearer token. It is re-read periodically so rotated tokens are used.
+	TokenFile string `mapstructure:"kubernetes_token_file"`
+	// ClientCertFile and ClientKeyFile are the client certificate and key
+	// the proxy authenticates to the upstream with instead of a token
+	ClientCertFile string `mapstructure:"kubernetes_client_cert_file"`
+	ClientKeyFile  string `mapstructure:"kubernetes_client_key_file"`
+	// CAFile verifies the upstream's certificate
+	// If not set, the system trust store is used
+	CAFile string `mapstructure:"kubernetes_ca_file"`
+
+	// UserPrefix and GroupPrefix are prepended to the impersonated user and
+	// groups, like the API server's `--oidc-username-prefix` and
+	// `--oidc-groups-prefix`
+	UserPrefix  string `mapstructure:"kubernetes_user_prefix"`
+	GroupPrefix string `mapstructure:"kubernetes_group_prefix"`
+	// UserClaim is the ID token claim impersonated as the user
+	// If not set, or the session has no such claim, the session's email is
+	// used, or its user if it has none
+	UserClaim string `mapstructure:"kubernetes_user_claim"`
+	// GroupsClaim is the ID token claim impersonated as the groups
+	// If not set, or the session has no such claim, the session's groups
+	// are used
+	GroupsClaim string `mapstructure:"kubernetes_groups_claim"`
+	// ExtraClaims maps user extra keys to the ID token claims sent as their
+	// values in `Impersonate-Extra-*` headers
+	ExtraClaims map[string]string `mapstructure:"kubernetes_extra_claims"`
+}
+
+func kubernetesDefaults() KubernetesOptions {
+	return KubernetesOptions{
+		UpstreamURL: "",
+		UserPrefix:  "",
+		GroupPrefix: "",
+	}
+}
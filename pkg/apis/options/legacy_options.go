@@ -2,6 +2,7 @@ package options
 
 import (
 	"fmt"
+	"time"
 )
 
 type LegacyOptions struct {
@@ -30,42 +31,62 @@ func (l *LegacyOptions) ToOptions() (*Options, error) {
 }
 
 type LegacyProvider struct {
-	ClientID                           string   `mapstructure:"client_id"`
-	ClientSecret                       string   `mapstructure:"client_secret"`
-	ClientSecretFile                   string   `mapstructure:"client_secret_file"`
-	ProviderType                       string   `mapstructure:"provider"`
-	ProviderName                       string   `mapstructure:"provider_display_name"`
-	ProviderCAFiles                    []string `mapstructure:"provider_ca_files"`
-	UseSystemTrustStore                bool     `mapstructure:"use_system_trust_store"`
-	OIDCIssuerURL                      string   `mapstructure:"oidc_issuer_url"`
-	InsecureOIDCAllowUnverifiedEmail   bool     `mapstructure:"insecure_oidc_allow_unverified_email"`
-	InsecureOIDCSkipIssuerVerification bool     `mapstructure:"insecure_oidc_skip_issuer_verification"`
-	InsecureOIDCSkipNonce              bool     `mapstructure:"insecure_oidc_skip_nonce"`
-	SkipOIDCDiscovery                  bool     `mapstructure:"skip_oidc_discovery"`
-	OIDCJwksURL                        string   `mapstructure:"oidc_jwks_url"`
-	OIDCEmailClaim                     string   `mapstructure:"oidc_email_claim"`
-	OIDCGroupsClaim                    string   `mapstructure:"oidc_groups_claim"`
-	OIDCAudienceClaims                 []string `mapstructure:"oidc_audience_claims"`
-	OIDCExtraAudiences                 []string `mapstructure:"oidc_extra_audiences"`
-	LoginURL                           string   `mapstructure:"login_url"`
-	RedeemURL                          string   `mapstructure:"redeem_url"`
-	ProfileURL                         string   `mapstructure:"profile_url"`
-	SkipClaimsFromProfileURL           bool     `mapstructure:"skip_claims_from_profile_url"`
-	ProtectedResource                  string   `mapstructure:"resource"`
-	ValidateURL                        string   `mapstructure:"validate_url"`
-	Scope                              string   `mapstructure:"scope"`
-	Prompt                             string   `mapstructure:"prompt"`
-	ApprovalPrompt                     string   `mapstructure:"approval_prompt"`
-	UserIDClaim                        string   `mapstructure:"user_id_claim"`
-	AllowedGroups                      []string `mapstructure:"allowed_groups"`
-	AllowedRoles                       []string `mapstructure:"allowed_roles"`
-	BackendLogoutURL                   string   `mapstructure:"backend_logout_url"`
-	AcrValues                          string   `mapstructure:"acr_values"`
-	JWTKey                             string   `mapstructure:"jwt_key"`
-	JWTKeyFile                         string   `mapstructure:"jwt_key_file"`
-	PubJWKURL                          string   `mapstructure:"pubjwk_url"`
-	CodeChallengeMethod                string   `mapstructure:"code_challenge_method"`
-	ForceCodeChallengeMethod           string   `mapstructure:"force_code_challenge_method"`
+	ClientID                           string        `mapstructure:"client_id"`
+	ClientSecret                       string        `mapstructure:"client_secret"`
+	ClientSecretFile                   string        `mapstructure:"client_secret_file"`
+	TokenEndpointAuthMethod            string        `mapstructure:"token_endpoint_auth_method"`
+	TLSClientCertFile                  string        `mapstructure:"tls_client_cert_file"`
+	TLSClientKeyFile                   string        `mapstructure:"tls_client_key_file"`
+	DPoP                               bool          `mapstructure:"dpop"`
+	DPoPKeyFile                        string        `mapstructure:"dpop_key_file"`
+	ProviderType                       string        `mapstructure:"provider"`
+	ProviderName                       string        `mapstructure:"provider_display_name"`
+	ProviderCAFiles                    []string      `mapstructure:"provider_ca_files"`
+	UseSystemTrustStore                bool          `mapstructure:"use_system_trust_store"`
+	OIDCIssuerURL                      string        `mapstructure:"oidc_issuer_url"`
+	InsecureOIDCAllowUnverifiedEmail   bool          `mapstructure:"insecure_oidc_allow_unverified_email"`
+	InsecureOIDCSkipIssuerVerification bool          `mapstructure:"insecure_oidc_skip_issuer_verification"`
+	InsecureOIDCSkipNonce              bool          `mapstructure:"insecure_oidc_skip_nonce"`
+	SkipOIDCDiscovery                  bool          `mapstructure:"skip_oidc_discovery"`
+	OIDCJwksURL                        string        `mapstructure:"oidc_jwks_url"`
+	OIDCEmailClaim                     string        `mapstructure:"oidc_email_claim"`
+	OIDCGroupsClaim                    string        `mapstructure:"oidc_groups_claim"`
+	OIDCAudienceClaims                 []string      `mapstructure:"oidc_audience_claims"`
+	OIDCExtraAudiences                 []string      `mapstructure:"oidc_extra_audiences"`
+	OIDCRefreshRetries                 int           `mapstructure:"oidc_refresh_retries"`
+	OIDCRefreshTokenGracePeriod        time.Duration `mapstructure:"oidc_refresh_token_grace_period"`
+	LoginURL                           string        `mapstructure:"login_url"`
+	RedeemURL                          string        `mapstructure:"redeem_url"`
+	ProfileURL                         string        `mapstructure:"profile_url"`
+	SkipClaimsFromProfileURL           bool          `mapstructure:"skip_claims_from_profile_url"`
+	ProtectedResource                  string        `mapstructure:"resource"`
+	ValidateURL                        string        `mapstructure:"validate_url"`
+	RevocationURL                      string        `mapstructure:"revocation_url"`
+	DeviceAuthorizationURL             string        `mapstructure:"device_authorization_url"`
+	PushedAuthorizationRequests        bool          `mapstructure:"pushed_authorization_requests"`
+	PushedAuthorizationRequestURL      string        `mapstructure:"pushed_authorization_request_url"`
+	SignedRequestObject                bool          `mapstructure:"signed_request_object"`
+	JARM                               bool          `mapstructure:"jarm"`
+	ResponseMode                       string        `mapstructure:"response_mode"`
+	IntrospectionURL                   string        `mapstructure:"introspection_url"`
+	IntrospectionCacheTTL              time.Duration `mapstructure:"introspection_cache_ttl"`
+	IntrospectionUserField             string        `mapstructure:"introspection_user_field"`
+	IntrospectionEmailField            string        `mapstructure:"introspection_email_field"`
+	IntrospectionGroupsField           string        `mapstructure:"introspection_groups_field"`
+	Scope                              string        `mapstructure:"scope"`
+	Prompt                             string        `mapstructure:"prompt"`
+	ApprovalPrompt                     string        `mapstructure:"approval_prompt"`
+	UserIDClaim                        string        `mapstructure:"user_id_claim"`
+	AllowedGroups                      []string      `mapstructure:"allowed_groups"`
+	AllowedRoles                       []string      `mapstructure:"allowed_roles"`
+	BackendLogoutURL                   string        `mapstructure:"backend_logout_url"`
+	AcrValues                          string        `mapstructure:"acr_values"`
+	JWTKey                             string        `mapstructure:"jwt_key"`
+	JWTKeyFile                         string        `mapstructure:"jwt_key_file"`
+	JWTKeyID                           string        `mapstructure:"jwt_key_id"`
+	PubJWKURL                          string        `mapstructure:"pubjwk_url"`
+	CodeChallengeMethod                string        `mapstructure:"code_challenge_method"`
+	ForceCodeChallengeMethod           string        `mapstructure:"force_code_challenge_method"`
 }
 
 func legacyProviderDefaults() LegacyProvider {
@@ -73,6 +94,11 @@ func legacyProviderDefaults() LegacyProvider {
 		ClientID:                           "",
 		ClientSecret:                       "",
 		ClientSecretFile:                   "",
+		TokenEndpointAuthMethod:            "",
+		TLSClientCertFile:                  "",
+		TLSClientKeyFile:                   "",
+		DPoP:                               false,
+		DPoPKeyFile:                        "",
 		ProviderType:                       "oidc",
 		ProviderName:                       "",
 		ProviderCAFiles:                    nil,
@@ -87,12 +113,26 @@ func legacyProviderDefaults() LegacyProvider {
 		OIDCGroupsClaim:                    OIDCGroupsClaim,
 		OIDCAudienceClaims:                 []string{"aud"},
 		OIDCExtraAudiences:                 nil,
+		OIDCRefreshRetries:                 2,
+		OIDCRefreshTokenGracePeriod:        30 * time.Second,
 		LoginURL:                           "",
 		RedeemURL:                          "",
 		ProfileURL:                         "",
 		SkipClaimsFromProfileURL:           false,
 		ProtectedResource:                  "",
 		ValidateURL:                        "",
+		RevocationURL:                      "",
+		DeviceAuthorizationURL:             "",
+		PushedAuthorizationRequests:        false,
+		PushedAuthorizationRequestURL:      "",
+		SignedRequestObject:                false,
+		JARM:                               false,
+		ResponseMode:                       "",
+		IntrospectionURL:                   "",
+		IntrospectionCacheTTL:              time.Minute,
+		IntrospectionUserField:             "username",
+		IntrospectionEmailField:            OIDCEmailClaim,
+		IntrospectionGroupsField:           OIDCGroupsClaim,
 		Scope:                              "",
 		Prompt:                             "",
 		ApprovalPrompt:                     "force",
@@ -103,6 +143,7 @@ func legacyProviderDefaults() LegacyProvider {
 		AcrValues:                          "",
 		JWTKey:                             "",
 		JWTKeyFile:                         "",
+		JWTKeyID:                           "",
 		PubJWKURL:                          "",
 		CodeChallengeMethod:                "",
 		ForceCodeChallengeMethod:           "",
@@ -116,6 +157,14 @@ func (l *LegacyProvider) convert() (Providers, error) {
 		ClientID:                 l.ClientID,
 		ClientSecret:             l.ClientSecret,
 		ClientSecretFile:         l.ClientSecretFile,
+		TokenEndpointAuthMethod:  l.TokenEndpointAuthMethod,
+		JWTKey:                   l.JWTKey,
+		JWTKeyFile:               l.JWTKeyFile,
+		JWTKeyID:                 l.JWTKeyID,
+		TLSClientCertFile:        l.TLSClientCertFile,
+		TLSClientKeyFile:         l.TLSClientKeyFile,
+		DPoP:                     l.DPoP,
+		DPoPKeyFile:              l.DPoPKeyFile,
 		Type:                     ProviderType(l.ProviderType),
 		CAFiles:                  l.ProviderCAFiles,
 		UseSystemTrustStore:      l.UseSystemTrustStore,
@@ -125,12 +174,20 @@ func (l *LegacyProvider) convert() (Providers, error) {
 		SkipClaimsFromProfileURL: l.SkipClaimsFromProfileURL,
 		ProtectedResource:        l.ProtectedResource,
 		ValidateURL:              l.ValidateURL,
+		RevocationURL:            l.RevocationURL,
+		DeviceAuthorizationURL:   l.DeviceAuthorizationURL,
 		Scope:                    l.Scope,
 		AllowedGroups:            l.AllowedGroups,
 		CodeChallengeMethod:      l.CodeChallengeMethod,
 		BackendLogoutURL:         l.BackendLogoutURL,
 	}
 
+	provider.PushedAuthorizationRequests = l.PushedAuthorizationRequests
+	provider.PushedAuthorizationRequestURL = l.PushedAuthorizationRequestURL
+	provider.SignedRequestObject = l.SignedRequestObject
+	provider.JARM = l.JARM
+	provider.ResponseMode = l.ResponseMode
+
 	// This part is out of the switch section for all providers that support OIDC
 	provider.OIDCConfig = OIDCOptions{
 		IssuerURL:                      l.OIDCIssuerURL,
@@ -144,6 +201,16 @@ func (l *LegacyProvider) convert() (Providers, error) {
 		GroupsClaim:                    l.OIDCGroupsClaim,
 		AudienceClaims:                 l.OIDCAudienceClaims,
 		ExtraAudiences:                 l.OIDCExtraAudiences,
+		RefreshRetries:                 l.OIDCRefreshRetries,
+		RefreshTokenGracePeriod:        l.OIDCRefreshTokenGracePeriod,
+	}
+
+	provider.Introspection = IntrospectionOptions{
+		URL:         l.IntrospectionURL,
+		CacheTTL:    l.IntrospectionCacheTTL,
+		UserField:   l.IntrospectionUserField,
+		EmailField:  l.IntrospectionEmailField,
+		GroupsField: l.IntrospectionGroupsField,
 	}
 
 	// Support for legacy configuration option
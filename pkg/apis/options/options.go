@@ -3,6 +3,8 @@ package options
 import (
 	"crypto"
 	"net/url"
+
+	ipapi "oidc/pkg/apis/ip"
 )
 
 // SignatureData holds hmacauth signature hash and key
@@ -11,11 +13,22 @@ type SignatureData struct {
 	Key  string
 }
 
+const (
+	// FormPostCSRFInterstitial re-submits form_post callbacks from the
+	// proxy's origin so the CSRF cookie is sent
+	FormPostCSRFInterstitial = "interstitial"
+
+	// FormPostCSRFSameSiteNone issues the CSRF cookie with `SameSite=None`
+	// so it is sent with the cross-site form_post callback
+	FormPostCSRFSameSiteNone = "samesite_none"
+)
+
 // Options holds Configuration Options that can be set by Command Line Flag,
 // or Config File
 type Options struct {
 	ProxyPrefix         string `mapstructure:"proxy_prefix"`
 	ReverseProxy        bool   `mapstructure:"reverse_proxy"`
+	RealClientIPHeader  string `mapstructure:"real_client_ip_header"`
 	RawRedirectURL      string `mapstructure:"redirect_url"`
 	RelativeRedirectURL bool   `mapstructure:"relative_redirect_url"`
 
@@ -25,30 +38,82 @@ type Options struct {
 
 	Cookie  Cookie         `mapstructure:",squash"`
 	Session SessionOptions `mapstructure:",squash"`
+	Admin   AdminOptions   `mapstructure:",squash"`
+	BFF     BFFOptions     `mapstructure:",squash"`
+
+	PreserveRequest PreserveRequestOptions `mapstructure:",squash"`
+	AuthzCallout    AuthzCalloutOptions    `mapstructure:",squash"`
+	Kubernetes      KubernetesOptions      `mapstructure:",squash"`
+
+	DeviceFlow DeviceFlowOptions `mapstructure:",squash"`
+
+	StepUpRoutes        []StepUpRoute        `mapstructure:"step_up_routes"`
+	TokenExchangeRoutes []TokenExchangeRoute `mapstructure:"token_exchange_routes"`
+	DPoPRoutes          []DPoPRoute          `mapstructure:"dpop_routes"`
+	PolicyRoutes        []PolicyRoute        `mapstructure:"policy_routes"`
 
 	Providers Providers
 
+	SessionInfoClaims        []string `mapstructure:"session_info_claims"`
+	SessionInfoIncludeTokens bool     `mapstructure:"session_info_include_tokens"`
+
+	MetricsAddress string `mapstructure:"metrics_address"`
+
+	// ExtAuthzAddress is where the Envoy ext_authz gRPC service listens
+	// If not set, the service is not started
+	ExtAuthzAddress string `mapstructure:"ext_authz_address"`
+
 	SSLInsecureSkipVerify bool `mapstructure:"ssl_insecure_skip_verify"`
 	SkipAuthPreflight     bool `mapstructure:"skip_auth_preflight"`
-	EncodeState           bool `mapstructure:"encode_state"`
+
+	// SilentSSO first tries to authenticate users without a session with a
+	// `prompt=none` authorization request, falling back to an interactive
+	// login when the provider requires user interaction. It also serves the
+	// silent authentication endpoint for hidden iframes.
+	SilentSSO bool `mapstructure:"silent_sso"`
+
+	// FormPostCSRF is how the CSRF cookie reaches form_post callbacks, which
+	// arrive as cross-site POSTs: "interstitial" re-submits the callback from
+	// the proxy's own origin, "samesite_none" issues the CSRF cookie with
+	// `SameSite=None`
+	FormPostCSRF string `mapstructure:"form_post_csrf"`
+
+	// BearerTokenIntrospection authenticates API clients presenting an
+	// `Authorization: Bearer` access token by introspecting it
+	BearerTokenIntrospection bool `mapstructure:"bearer_token_introspection"`
+
+	// ProtectedResource is the RFC 9728 resource identifier advertised in
+	// the protected resource metadata. If not set, the origin of the request
+	// is used.
+	ProtectedResource string `mapstructure:"protected_resource"`
 
 	// internal values that are set after config validation
-	redirectURL *url.URL // 私有字段通常不需要 mapstructure 标签
+	redirectURL        *url.URL // 私有字段通常不需要 mapstructure 标签
+	realClientIPParser ipapi.RealClientIPParser
 }
 
 // Options for Getting internal values
-func (o *Options) GetRedirectURL() *url.URL { return o.redirectURL }
+func (o *Options) GetRedirectURL() *url.URL                        { return o.redirectURL }
+func (o *Options) GetRealClientIPParser() ipapi.RealClientIPParser { return o.realClientIPParser }
 
 // Options for Setting internal values
-func (o *Options) SetRedirectURL(s *url.URL) { o.redirectURL = s }
+func (o *Options) SetRedirectURL(s *url.URL)                        { o.redirectURL = s }
+func (o *Options) SetRealClientIPParser(s ipapi.RealClientIPParser) { o.realClientIPParser = s }
 
 // NewOptions constructs a new Options with defaulted values
 func NewOptions() *Options {
 	return &Options{
-		ProxyPrefix:       "/oauth2",
-		Providers:         providerDefaults(),
-		Cookie:            cookieDefaults(),
-		Session:           sessionOptionsDefaults(),
-		SkipAuthPreflight: false,
+		ProxyPrefix:        "/oauth2",
+		RealClientIPHeader: "X-Real-IP",
+		Providers:          providerDefaults(),
+		Cookie:             cookieDefaults(),
+		Session:            sessionOptionsDefaults(),
+		BFF:                bffDefaults(),
+		DeviceFlow:         deviceFlowDefaults(),
+		SkipAuthPreflight:  false,
+		FormPostCSRF:       FormPostCSRFInterstitial,
+		PreserveRequest:    preserveRequestDefaults(),
+		AuthzCallout:       authzCalloutDefaults(),
+		Kubernetes:         kubernetesDefaults(),
 	}
 }
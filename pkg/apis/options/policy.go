@@ -0,0 +1,19 @@
+package options
+
+// PolicyRoute authorizes requests whose path matches Path, and whose method
+// is one of Methods, with a CEL expression over the session and the request.
+// The first matching route applies, requests matching no route are only
+// subject to the global email and group checks.
+type PolicyRoute struct {
+	// Name identifies the route in logs and policy tests
+	Name string `mapstructure:"name"`
+	// Path is a regular expression matched against the request path, which
+	// is taken from `X-Forwarded-Uri` when reverse_proxy is set
+	Path string `mapstructure:"path"`
+	// Methods limits the route to these HTTP methods
+	// If not set, the route applies to every method
+	Methods []string `mapstructure:"methods"`
+	// Policy is the CEL expression that must evaluate to true, for example
+	// `"sre" in groups && request.method == "GET"`
+	Policy string `mapstructure:"policy"`
+}
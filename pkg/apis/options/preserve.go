@@ -0,0 +1,40 @@
+package options
+
+const (
+	// PreserveStoreCookie keeps a preserved request body in an encrypted
+	// cookie
+	PreserveStoreCookie = "cookie"
+
+	// PreserveStoreSession keeps a preserved request body in the server side
+	// session store, with only its key in a cookie
+	PreserveStoreSession = "session"
+)
+
+// PreserveRequestOptions contains configuration for carrying the parts of a
+// request that the login redirects would lose over to the request made once
+// the user is authenticated.
+type PreserveRequestOptions struct {
+	// PostBody stashes the url encoded body of a same-origin form POST that
+	// requires a login and replays it to the upstream after the callback.
+	// The POST is stashed before there is a session, so it isn't checked for
+	// the `bff_enforce_csrf_header` header, and the replay is a plain form
+	// submission without it, which that option rejects.
+	PostBody bool `mapstructure:"preserve_post_body"`
+	// PostBodyLimit is the size in bytes of the largest body preserved
+	PostBodyLimit int64 `mapstructure:"preserve_post_body_limit"`
+	// PostBodyStore is where the body is kept during the login, either
+	// "cookie" or "session"
+	PostBodyStore string `mapstructure:"preserve_post_body_store"`
+	// Fragment serves a page capturing the URL fragment into the redirect
+	// before the login starts, since browsers never send it to the server
+	Fragment bool `mapstructure:"preserve_fragment"`
+}
+
+func preserveRequestDefaults() PreserveRequestOptions {
+	return PreserveRequestOptions{
+		PostBody:      false,
+		PostBodyLimit: 2048,
+		PostBodyStore: PreserveStoreCookie,
+		Fragment:      false,
+	}
+}
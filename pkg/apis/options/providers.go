@@ -1,5 +1,7 @@
 package options
 
+import "time"
+
 const (
 	// OIDCEmailClaim is the generic email claim used by the OIDC provider.
 	OIDCEmailClaim = "email"
@@ -8,6 +10,37 @@ const (
 	OIDCGroupsClaim = "groups"
 )
 
+const (
+	// ClientSecretBasicAuthMethod sends the client secret with HTTP Basic
+	// authentication
+	ClientSecretBasicAuthMethod = "client_secret_basic"
+
+	// ClientSecretPostAuthMethod sends the client secret in the request body
+	ClientSecretPostAuthMethod = "client_secret_post"
+
+	// PrivateKeyJWTAuthMethod authenticates with an RFC 7523 client assertion
+	// signed by the client's private key
+	PrivateKeyJWTAuthMethod = "private_key_jwt"
+
+	// TLSClientAuthMethod authenticates with a PKI client certificate over
+	// RFC 8705 mutual TLS
+	TLSClientAuthMethod = "tls_client_auth"
+
+	// SelfSignedTLSClientAuthMethod authenticates with a self-signed client
+	// certificate over RFC 8705 mutual TLS
+	SelfSignedTLSClientAuthMethod = "self_signed_tls_client_auth"
+)
+
+const (
+	// ResponseModeQuery returns the authorization response in the query of
+	// the redirect to the callback
+	ResponseModeQuery = "query"
+
+	// ResponseModeFormPost returns the authorization response in a form
+	// POSTed to the callback
+	ResponseModeFormPost = "form_post"
+)
+
 // OIDCAudienceClaims is the generic audience claim list used by the OIDC provider.
 var OIDCAudienceClaims = []string{"aud"}
 
@@ -25,9 +58,40 @@ type Provider struct {
 	// ClientSecretFile is the name of the file
 	// containing the OAuth Client Secret, it will be used if ClientSecret is not set.
 	ClientSecretFile string `json:"clientSecretFile,omitempty"`
+	// TokenEndpointAuthMethod is how the client authenticates at the token
+	// endpoint: client_secret_basic, client_secret_post, private_key_jwt,
+	// tls_client_auth or self_signed_tls_client_auth.
+	// If not set, the client secret is sent in the way the provider accepts
+	TokenEndpointAuthMethod string `json:"tokenEndpointAuthMethod,omitempty"`
+	// JWTKey is a RSA or EC private key in PEM format used to sign the
+	// private_key_jwt client assertions
+	JWTKey string `json:"jwtKey,omitempty"`
+	// JWTKeyFile is a path to the private key in PEM or JWK format used to
+	// sign the private_key_jwt client assertions, it will be used if JWTKey
+	// is not set.
+	JWTKeyFile string `json:"jwtKeyFile,omitempty"`
+	// JWTKeyID is the `kid` of the client assertions' signing key
+	// If not set, the `kid` of a JWK is used
+	JWTKeyID string `json:"jwtKeyID,omitempty"`
+	// TLSClientCertFile is a path to the PEM client certificate presented for
+	// tls_client_auth and self_signed_tls_client_auth
+	TLSClientCertFile string `json:"tlsClientCertFile,omitempty"`
+	// TLSClientKeyFile is a path to the PEM private key of the client certificate
+	TLSClientKeyFile string `json:"tlsClientKeyFile,omitempty"`
+	// DPoP binds the tokens issued to the proxy to its key pair with RFC 9449
+	// DPoP proofs sent to the token endpoint
+	DPoP bool `json:"dpop,omitempty"`
+	// DPoPKeyFile is a path to the RSA or EC private key in PEM or JWK format
+	// that DPoP proofs are signed with.
+	// If not set, a key is generated on startup and tokens bound to it can't
+	// be used after a restart or by other instances, so it is required
+	// unless sessions are kept in memory
+	DPoPKeyFile string `json:"dpopKeyFile,omitempty"`
 	// OIDCConfig holds all configurations for OIDC provider
 	// or providers utilize OIDC configurations.
 	OIDCConfig OIDCOptions `json:"oidcConfig,omitempty"`
+	// Introspection holds the RFC 7662 token introspection configuration
+	Introspection IntrospectionOptions `json:"introspection,omitempty"`
 	// ID should be a unique identifier for the provider.
 	// This value is required for all providers.
 	ID string `json:"id,omitempty"`
@@ -59,6 +123,30 @@ type Provider struct {
 	ProtectedResource string `json:"resource,omitempty"`
 	// ValidateURL is the access token validation endpoint
 	ValidateURL string `json:"validateURL,omitempty"`
+	// RevocationURL is the RFC 7009 token revocation endpoint
+	// If not set, the `revocation_endpoint` from OIDC discovery is used
+	RevocationURL string `json:"revocationURL,omitempty"`
+	// DeviceAuthorizationURL is the RFC 8628 device authorization endpoint
+	// If not set, the `device_authorization_endpoint` from OIDC discovery is used
+	DeviceAuthorizationURL string `json:"deviceAuthorizationURL,omitempty"`
+	// PushedAuthorizationRequests sends the login parameters to the RFC 9126
+	// pushed authorization request endpoint and only passes the returned
+	// `request_uri` through the browser. It is always enabled when the
+	// provider sets `require_pushed_authorization_requests`.
+	PushedAuthorizationRequests bool `json:"pushedAuthorizationRequests,omitempty"`
+	// PushedAuthorizationRequestURL is the RFC 9126 pushed authorization request endpoint
+	// If not set, the `pushed_authorization_request_endpoint` from OIDC discovery is used
+	PushedAuthorizationRequestURL string `json:"pushedAuthorizationRequestURL,omitempty"`
+	// SignedRequestObject sends the login parameters in an RFC 9101 request
+	// object signed with the JWTKey. It is always enabled when the provider
+	// sets `require_signed_request_object`.
+	SignedRequestObject bool `json:"signedRequestObject,omitempty"`
+	// ResponseMode is the `response_mode` of authorization requests: query or
+	// form_post. If not set, the provider's default for the code flow is used
+	ResponseMode string `json:"responseMode,omitempty"`
+	// JARM asks for JWT secured authorization responses and only accepts
+	// callbacks whose `response` JWT is signed by the provider
+	JARM bool `json:"jarm,omitempty"`
 	// Scope is the OAuth scope specification
 	Scope string `json:"scope,omitempty"`
 	// AllowedGroups is a list of restrict logins to members of this group
@@ -157,6 +245,37 @@ type OIDCOptions struct {
 	// ExtraAudiences is a list of additional audiences that are allowed
 	// to pass verification in addition to the client id.
 	ExtraAudiences []string `json:"extraAudiences,omitempty"`
+	// RefreshRetries is how many times a refresh that failed with a transient
+	// error (network failure, 429 or 5xx) is retried with exponential backoff
+	// default set to 2
+	RefreshRetries int `json:"refreshRetries,omitempty"`
+	// RefreshTokenGracePeriod is how long the tokens issued for a rotated
+	// refresh token are reused for concurrent refreshes that still present
+	// the old refresh token, instead of sending it to the provider again.
+	// default set to 30s, 0 disables it
+	RefreshTokenGracePeriod time.Duration `json:"refreshTokenGracePeriod,omitempty"`
+}
+
+type IntrospectionOptions struct {
+	// URL is the RFC 7662 token introspection endpoint. When set, access
+	// tokens are validated by introspection. Bearer tokens are only accepted
+	// when their audience or `client_id` is the client ID or one of the
+	// extra audiences.
+	URL string `json:"url,omitempty"`
+	// CacheTTL is how long an active introspection result is reused. It is
+	// never reused past the token's `exp`. Inactive results are reused for
+	// at most 10 seconds.
+	// default set to 1m, 0 disables caching
+	CacheTTL time.Duration `json:"cacheTTL,omitempty"`
+	// UserField is the introspection response field holding the user
+	// default set to 'username', falling back to 'sub'
+	UserField string `json:"userField,omitempty"`
+	// EmailField is the introspection response field holding the email
+	// default set to 'email'
+	EmailField string `json:"emailField,omitempty"`
+	// GroupsField is the introspection response field holding the groups
+	// default set to 'groups'
+	GroupsField string `json:"groupsField,omitempty"`
 }
 
 type LoginGovOptions struct {
@@ -181,6 +300,14 @@ func providerDefaults() Providers {
 				GroupsClaim:                  OIDCGroupsClaim,
 				AudienceClaims:               OIDCAudienceClaims,
 				ExtraAudiences:               []string{},
+				RefreshRetries:               2,
+				RefreshTokenGracePeriod:      30 * time.Second,
+			},
+			Introspection: IntrospectionOptions{
+				CacheTTL:    time.Minute,
+				UserField:   "username",
+				EmailField:  OIDCEmailClaim,
+				GroupsField: OIDCGroupsClaim,
 			},
 		},
 	}
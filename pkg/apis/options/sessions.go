@@ -1,15 +1,36 @@
 package options
 
+import "time"
+
 // SessionOptions contains configuration options for the SessionStore providers.
 type SessionOptions struct {
 	Type   string             `mapstructure:"session_store_type"`
 	Cookie CookieStoreOptions `mapstructure:",squash"`
+
+	// RefreshBeforeExpiry refreshes sessions whose access token expires
+	// within this duration. It can be combined with the cookie age based
+	// `cookie_refresh` and is disabled when 0.
+	RefreshBeforeExpiry time.Duration `mapstructure:"session_refresh_before_expiry"`
+	// BackgroundRefresh serves requests with the current session while it
+	// is refreshed asynchronously. Requires a server side session store.
+	BackgroundRefresh bool `mapstructure:"session_background_refresh"`
+
+	// IdleTimeout ends sessions that have not been used for a request within
+	// this duration. Disabled when 0.
+	IdleTimeout time.Duration `mapstructure:"session_idle_timeout"`
+	// MaxLifetime ends sessions this long after the user originally logged
+	// in, regardless of refreshes. Disabled when 0.
+	MaxLifetime time.Duration `mapstructure:"session_max_lifetime"`
 }
 
 // CookieSessionStoreType is used to indicate the CookieSessionStore should be
 // used for storing sessions.
 var CookieSessionStoreType = "cookie"
 
+// MemorySessionStoreType is used to indicate the in-memory SessionStore
+// should be used for storing sessions.
+var MemorySessionStoreType = "memory"
+
 // CookieStoreOptions contains configuration options for the CookieSessionStore.
 type CookieStoreOptions struct {
 	Minimal bool `mapstructure:"session_cookie_minimal"`
@@ -21,5 +42,9 @@ func sessionOptionsDefaults() SessionOptions {
 		Cookie: CookieStoreOptions{
 			Minimal: false,
 		},
+		RefreshBeforeExpiry: time.Duration(0),
+		BackgroundRefresh:   false,
+		IdleTimeout:         time.Duration(0),
+		MaxLifetime:         time.Duration(0),
 	}
 }
@@ -0,0 +1,20 @@
+package options
+
+import "time"
+
+// StepUpRoute requires a stronger authentication context for requests whose
+// path matches Path. Sessions that don't meet every configured requirement
+// are sent back to the provider to authenticate again.
+type StepUpRoute struct {
+	// Path is a regular expression matched against the request path
+	Path string `mapstructure:"path"`
+	// ACRValues lists the `acr` values that satisfy the route. They are
+	// requested in order of preference with the `acr_values` parameter.
+	ACRValues []string `mapstructure:"acr_values"`
+	// AMR lists authentication methods that must all be present in the
+	// session's `amr` claim
+	AMR []string `mapstructure:"amr"`
+	// MaxAge is the maximum time since the user last actively authenticated
+	// at the provider, taken from the `auth_time` claim. Sent as `max_age`.
+	MaxAge time.Duration `mapstructure:"max_age"`
+}
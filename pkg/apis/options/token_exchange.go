@@ -0,0 +1,16 @@
+package options
+
+// TokenExchangeRoute exchanges the session's access token with RFC 8693
+// token exchange for a token issued to the upstream serving requests whose
+// path matches Path. The exchanged token is passed upstream in the
+// Authorization header.
+type TokenExchangeRoute struct {
+	// Path is a regular expression matched against the request path
+	Path string `mapstructure:"path"`
+	// Audience is the logical name of the upstream the token is for
+	Audience string `mapstructure:"audience"`
+	// Resource is the URI of the upstream the token is for
+	Resource string `mapstructure:"resource"`
+	// Scope is the space separated scope requested for the token
+	Scope string `mapstructure:"scope"`
+}
@@ -15,8 +15,53 @@ type SessionStore interface {
 	VerifyConnection(ctx context.Context) error
 }
 
+// SessionIndex is implemented by server side session stores that can look up
+// and remove sessions without the user's cookie, using the OIDC `sid` and
+// `sub` the session was created with, or the user it belongs to.
+type SessionIndex interface {
+	// ClearBySessionID removes every session recorded with the given IdP
+	// session ID and returns how many were removed.
+	ClearBySessionID(ctx context.Context, sid string) (int, error)
+	// ClearBySubject removes every session recorded for the given subject
+	// and returns how many were removed.
+	ClearBySubject(ctx context.Context, sub string) (int, error)
+	// ListByUser returns the active sessions of the user, matched by
+	// either subject or email.
+	ListByUser(ctx context.Context, user string) ([]SessionInfo, error)
+	// LoadByID loads the session stored under the ID from its SessionInfo
+	LoadByID(ctx context.Context, id string) (*SessionState, error)
+	// ClearByID removes the session stored under the ID from its SessionInfo
+	ClearByID(ctx context.Context, id string) error
+}
+
+// DataStore is implemented by server side session stores that can also keep
+// short lived data that isn't a session, such as a request body preserved
+// across a login. The data is stored as given, callers encrypt it.
+type DataStore interface {
+	// SaveData stores value under key until exp has elapsed
+	SaveData(ctx context.Context, key string, value []byte, exp time.Duration) error
+	// LoadData loads the value stored under key
+	LoadData(ctx context.Context, key string) ([]byte, error)
+	// ClearData removes the value stored under key
+	ClearData(ctx context.Context, key string) error
+}
+
+// SessionInfo describes a stored session without any of its tokens
+type SessionInfo struct {
+	ID          string     `json:"id"`
+	User        string     `json:"user,omitempty"`
+	Email       string     `json:"email,omitempty"`
+	CreatedAt   *time.Time `json:"createdAt,omitempty"`
+	ExpiresOn   *time.Time `json:"expiresOn,omitempty"`
+	RefreshedAt *time.Time `json:"refreshedAt,omitempty"`
+	ClientIP    string     `json:"clientIP,omitempty"`
+	ProviderID  string     `json:"providerID,omitempty"`
+}
+
 var ErrLockNotObtained = errors.New("lock: not obtained")
 
+var ErrNotLocked = errors.New("lock: not locked")
+
 // Lock is an interface for controlling session locks
 type Lock interface {
 	// Obtain obtains the lock on the distributed
@@ -5,6 +5,10 @@ import (
 	"time"
 )
 
+// RefreshLockDuration is how long a session is locked for an attempt to
+// refresh it. A refresh that is retried extends the lock before each retry.
+const RefreshLockDuration = 2 * time.Second
+
 type NoOpLock struct{}
 
 func (l *NoOpLock) Obtain(_ context.Context, _ time.Duration) error {
@@ -16,8 +16,15 @@ import (
 
 // // SessionState is used to store information about the currently authenticated user session
 type SessionState struct {
-	CreatedAt *time.Time `json:"ca,omitempty"`
-	ExpiresOn *time.Time `json:"eo,omitempty"`
+	CreatedAt   *time.Time `json:"ca,omitempty"`
+	ExpiresOn   *time.Time `json:"eo,omitempty"`
+	RefreshedAt *time.Time `json:"ra,omitempty"`
+
+	// AuthenticatedAt is when the user originally logged in. Unlike
+	// CreatedAt it is not reset when the session is refreshed.
+	AuthenticatedAt *time.Time `json:"aa,omitempty"`
+	// LastSeenAt is when the session was last used for a request
+	LastSeenAt *time.Time `json:"ls,omitempty"`
 
 	AccessToken  string `json:"at,omitempty"`
 	IDToken      string `json:"it,omitempty"`
@@ -29,6 +36,27 @@ type SessionState struct {
 	User              string   `json:"u,omitempty"`
 	Groups            []string `json:"g,omitempty"`
 	PreferredUsername string   `json:"pu,omitempty"`
+	Scopes            []string `json:"sc,omitempty"`
+
+	// Subject is the `sub` claim of the ID token, which User only holds when
+	// the user claim is `sub`
+	Subject string `json:"sb,omitempty"`
+
+	// SessionID is the IdP session (`sid` claim) this session was created in
+	SessionID string `json:"si,omitempty"`
+
+	// ACR, AMR and IdPAuthTime hold the `acr`, `amr` and `auth_time` claims
+	// describing how the user authenticated at the provider
+	ACR         string     `json:"acr,omitempty"`
+	AMR         []string   `json:"amr,omitempty"`
+	IdPAuthTime *time.Time `json:"iat,omitempty"`
+
+	// DeviceFlow marks sessions created with the device authorization grant.
+	// They have no authorization request, so there is no nonce to check.
+	DeviceFlow bool `json:"df,omitempty"`
+
+	ClientIP   string `json:"ip,omitempty"`
+	ProviderID string `json:"pi,omitempty"`
 
 	// Internal helpers, not serialized
 	Clock clock.Clock `json:"-"`
@@ -69,6 +97,33 @@ func (s *SessionState) CreatedAtNow() {
 	s.CreatedAt = &now
 }
 
+// RefreshedAtNow sets a SessionState's RefreshedAt to now
+func (s *SessionState) RefreshedAtNow() {
+	now := s.Clock.Now()
+	s.RefreshedAt = &now
+}
+
+// AuthenticatedAtNow sets a SessionState's AuthenticatedAt to now
+func (s *SessionState) AuthenticatedAtNow() {
+	now := s.Clock.Now()
+	s.AuthenticatedAt = &now
+}
+
+// LastSeenAtNow sets a SessionState's LastSeenAt to now
+func (s *SessionState) LastSeenAtNow() {
+	now := s.Clock.Now()
+	s.LastSeenAt = &now
+}
+
+// AuthTime returns when the user originally logged in. Sessions created
+// before the authentication time was recorded fall back to CreatedAt.
+func (s *SessionState) AuthTime() *time.Time {
+	if s.AuthenticatedAt != nil && !s.AuthenticatedAt.IsZero() {
+		return s.AuthenticatedAt
+	}
+	return s.CreatedAt
+}
+
 // SetExpiresOn sets an expiration
 func (s *SessionState) SetExpiresOn(exp time.Time) {
 	s.ExpiresOn = &exp
@@ -121,6 +176,9 @@ func (s *SessionState) String() string {
 	if len(s.Groups) > 0 {
 		o += fmt.Sprintf(" groups:%v", s.Groups)
 	}
+	if s.SessionID != "" {
+		o += fmt.Sprintf(" sid:%s", s.SessionID)
+	}
 	return o + "}"
 }
 
@@ -149,6 +207,18 @@ func (s *SessionState) GetClaim(claim string) []string {
 		return groups
 	case "preferred_username":
 		return []string{s.PreferredUsername}
+	case "sid":
+		return []string{s.SessionID}
+	case "scope":
+		scopes := make([]string, len(s.Scopes))
+		copy(scopes, s.Scopes)
+		return scopes
+	case "acr":
+		return []string{s.ACR}
+	case "amr":
+		amr := make([]string, len(s.AMR))
+		copy(amr, s.AMR)
+		return amr
 	default:
 		return []string{}
 	}
@@ -72,10 +72,12 @@ func NewCSRF(opts *options.Cookie, codeVerifier string) (CSRF, error) {
 	}, nil
 }
 
-// LoadCSRFCookie loads a CSRF object from a request's CSRF cookie
-func LoadCSRFCookie(req *http.Request, opts *options.Cookie) (CSRF, error) {
+// LoadCSRFCookie loads a CSRF object from a request's CSRF cookie. The
+// hashed OAuth state from the state parameter selects the cookie when CSRF
+// cookies are per request.
+func LoadCSRFCookie(req *http.Request, opts *options.Cookie, hashedState string) (CSRF, error) {
 
-	cookieName := GenerateCookieName(req, opts)
+	cookieName := GenerateCookieName(opts, hashedState)
 
 	cookie, err := req.Cookie(cookieName)
 	if err != nil {
@@ -86,13 +88,13 @@ func LoadCSRFCookie(req *http.Request, opts *options.Cookie) (CSRF, error) {
 }
 
 // GenerateCookieName in case cookie options state that CSRF cookie has fixed name then set fixed name, otherwise
-// build name based on the state
-func GenerateCookieName(req *http.Request, opts *options.Cookie) string {
+// build name based on the hashed state
+func GenerateCookieName(opts *options.Cookie, hashedState string) string {
 	stateSubstring := ""
-	if opts.CSRFPerRequest {
+	if opts.CSRFPerRequest && len(hashedState) >= csrfStateLength-1 {
 		// csrfCookieName will include a substring of the state to enable multiple csrf cookies
 		// in case of parallel requests
-		stateSubstring = ExtractStateSubstring(req)
+		stateSubstring = hashedState[0 : csrfStateLength-1]
 	}
 	return csrfCookieName(opts, stateSubstring)
 }
@@ -214,21 +216,6 @@ func csrfCookieName(opts *options.Cookie, stateSubstring string) string {
 	return fmt.Sprintf("%v_csrf_%v", opts.Name, stateSubstring)
 }
 
-// ExtractStateSubstring extract the initial state characters, to add it to the CSRF cookie name
-func ExtractStateSubstring(req *http.Request) string {
-	lastChar := csrfStateLength - 1
-	stateSubstring := ""
-
-	state := req.URL.Query()["state"]
-	if state[0] != "" {
-		state := state[0]
-		if lastChar <= len(state) {
-			stateSubstring = state[0:lastChar]
-		}
-	}
-	return stateSubstring
-}
-
 func encrypt(data []byte, opts *options.Cookie) ([]byte, error) {
 	cipher, err := makeCipher(opts)
 	if err != nil {
@@ -0,0 +1,19 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+var (
+	// TokenRevocations counts RFC 7009 token revocation attempts by result
+	TokenRevocations = expvar.NewMap("oauth2_proxy_token_revocations_total")
+
+	// AuthzCallouts counts external authorization decisions by outcome
+	AuthzCallouts = expvar.NewMap("oauth2_proxy_authz_callouts_total")
+)
+
+// Handler returns an http.Handler serving all registered metrics as JSON
+func Handler() http.Handler {
+	return expvar.Handler()
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/justinas/alice"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	middlewareapi "oidc/pkg/apis/middleware"
+	sessionsapi "oidc/pkg/apis/sessions"
+	"oidc/providers"
+)
+
+// NewBearerSessionLoader creates a new bearerSessionLoader which builds
+// sessions from `Authorization: Bearer` tokens presented by API clients.
+// Requests without a bearer token are passed to the next handler untouched.
+func NewBearerSessionLoader(createSession func(context.Context, string) (*sessionsapi.SessionState, error)) alice.Constructor {
+	loader := &bearerSessionLoader{createSession: createSession}
+	return loader.loadSession
+}
+
+// bearerSessionLoader is responsible for loading sessions from bearer
+// tokens in the Authorization header
+type bearerSessionLoader struct {
+	createSession func(context.Context, string) (*sessionsapi.SessionState, error)
+}
+
+// loadSession attempts to load a session from the request's bearer token.
+// If a session was loaded by a previous handler, it will not be replaced.
+func (b *bearerSessionLoader) loadSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		scope := middlewareapi.GetRequestScope(req)
+		// If scope is nil, this will panic.
+		// A scope should always be injected before this handler is called.
+		if scope.Session != nil {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		token := bearerToken(req)
+		if token == "" {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		session, err := b.createSession(req.Context(), token)
+		if err != nil {
+			logger.PrintAuthf("", req, logger.AuthFailure, "Invalid bearer token: %v", err)
+			scope.BearerError = middlewareapi.BearerErrorInvalidToken
+			if errors.Is(err, providers.ErrIntrospectionUnavailable) {
+				scope.BearerError = middlewareapi.BearerErrorUnavailable
+			}
+		} else {
+			scope.Session = session
+			scope.BearerSession = true
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// bearerToken returns the token of an `Authorization: Bearer` header
+func bearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
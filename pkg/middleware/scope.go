@@ -5,6 +5,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/justinas/alice"
+	opmiddlewareapi "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/apis/middleware"
 	middlewareapi "oidc/pkg/apis/middleware"
 )
 
@@ -16,6 +17,12 @@ func NewScope(reverseProxy bool, idHeader string) alice.Constructor {
 				RequestID:    genRequestID(req, idHeader),
 			}
 			req = middlewareapi.AddRequestScope(req, scope)
+			// The logger reads the request ID from the upstream package's
+			// scope, and can't log requests without one
+			req = opmiddlewareapi.AddRequestScope(req, &opmiddlewareapi.RequestScope{
+				ReverseProxy: scope.ReverseProxy,
+				RequestID:    scope.RequestID,
+			})
 			next.ServeHTTP(rw, req)
 		})
 	}
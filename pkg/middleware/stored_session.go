@@ -22,13 +22,37 @@ const (
 
 	// Maximum time allowed for a session refresh attempt.
 	// If the refresh request isn't finished within this time, the lock will be
-	// released.
+	// released. Retried refreshes extend the lock by this much for each retry.
 	// TODO: This should probably be configurable by the end user.
-	sessionRefreshLockDuration = 2 * time.Second
+	sessionRefreshLockDuration = sessionsapi.RefreshLockDuration
 
 	// How long to wait after failing to obtain the lock before trying again.
 	// TODO: This should probably be configurable by the end user.
 	sessionRefreshRetryPeriod = 10 * time.Millisecond
+
+	// The last seen time is only persisted when it is older than this, so
+	// that active sessions are not saved on every request.
+	sessionLastSeenInterval = time.Minute
+)
+
+const (
+	// SessionEndReasonIdleTimeout is set on the request scope when a session
+	// was removed because it was not used within the idle timeout.
+	SessionEndReasonIdleTimeout = "idle_timeout"
+
+	// SessionEndReasonMaxLifetime is set on the request scope when a session
+	// was removed because it reached its maximum lifetime.
+	SessionEndReasonMaxLifetime = "max_lifetime"
+)
+
+var (
+	// ErrSessionIdleTimeout is returned when a session was not used within
+	// the idle timeout
+	ErrSessionIdleTimeout = errors.New("session idle timeout exceeded")
+
+	// ErrSessionMaxLifetime is returned when a session is older than the
+	// maximum session lifetime
+	ErrSessionMaxLifetime = errors.New("session maximum lifetime exceeded")
 )
 
 // StoredSessionLoaderOptions contains all the requirements to construct
@@ -41,6 +65,18 @@ type StoredSessionLoaderOptions struct {
 	// How often should sessions be refreshed
 	RefreshPeriod time.Duration
 
+	// Refresh sessions whose access token expires within this duration
+	RefreshBeforeExpiry time.Duration
+
+	// Serve the current session while refreshing it asynchronously
+	BackgroundRefresh bool
+
+	// End sessions that were not used within this duration
+	IdleTimeout time.Duration
+
+	// End sessions this long after the user logged in
+	MaxLifetime time.Duration
+
 	// Provider based session refreshing
 	RefreshSession func(context.Context, *sessionsapi.SessionState) (bool, error)
 
@@ -56,10 +92,14 @@ type StoredSessionLoaderOptions struct {
 // If a session was loader by a previous handler, it will not be replaced.
 func NewStoredSessionLoader(opts *StoredSessionLoaderOptions) alice.Constructor {
 	ss := &storedSessionLoader{
-		store:            opts.SessionStore,
-		refreshPeriod:    opts.RefreshPeriod,
-		sessionRefresher: opts.RefreshSession,
-		sessionValidator: opts.ValidateSession,
+		store:               opts.SessionStore,
+		refreshPeriod:       opts.RefreshPeriod,
+		refreshBeforeExpiry: opts.RefreshBeforeExpiry,
+		backgroundRefresh:   opts.BackgroundRefresh,
+		idleTimeout:         opts.IdleTimeout,
+		maxLifetime:         opts.MaxLifetime,
+		sessionRefresher:    opts.RefreshSession,
+		sessionValidator:    opts.ValidateSession,
 	}
 	return ss.loadSession
 }
@@ -67,10 +107,14 @@ func NewStoredSessionLoader(opts *StoredSessionLoaderOptions) alice.Constructor
 // storedSessionLoader is responsible for loading sessions from cookie
 // identified sessions in the session store.
 type storedSessionLoader struct {
-	store            sessionsapi.SessionStore
-	refreshPeriod    time.Duration
-	sessionRefresher func(context.Context, *sessionsapi.SessionState) (bool, error)
-	sessionValidator func(context.Context, *sessionsapi.SessionState) bool
+	store               sessionsapi.SessionStore
+	refreshPeriod       time.Duration
+	refreshBeforeExpiry time.Duration
+	backgroundRefresh   bool
+	idleTimeout         time.Duration
+	maxLifetime         time.Duration
+	sessionRefresher    func(context.Context, *sessionsapi.SessionState) (bool, error)
+	sessionValidator    func(context.Context, *sessionsapi.SessionState) bool
 }
 
 // loadSession attempts to load a session as identified by the request cookies.
@@ -92,6 +136,7 @@ func (s *storedSessionLoader) loadSession(next http.Handler) http.Handler {
 			// In the case when there was an error loading the session,
 			// we should clear the session
 			logger.Errorf("Error loading cookied session: %v, removing session", err)
+			scope.SessionEndReason = sessionEndReason(err)
 			err = s.store.Clear(rw, req)
 			if err != nil {
 				logger.Errorf("Error removing session: %v", err)
@@ -113,23 +158,82 @@ func (s *storedSessionLoader) getValidatedSession(rw http.ResponseWriter, req *h
 		return nil, err
 	}
 
+	if err := s.checkSessionLimits(session); err != nil {
+		return nil, fmt.Errorf("session (%s) ended: %w", session, err)
+	}
+
 	err = s.refreshSessionIfNeeded(rw, req, session)
 	if err != nil {
 		return nil, fmt.Errorf("error refreshing access token for session (%s): %v", session, err)
 	}
 
+	s.updateLastSeen(rw, req, session)
 	return session, nil
 }
 
+// checkSessionLimits enforces the idle timeout, measured since the session
+// was last used, and the maximum lifetime, measured since the user logged in.
+func (s *storedSessionLoader) checkSessionLimits(session *sessionsapi.SessionState) error {
+	now := session.Clock.Now()
+	if authTime := session.AuthTime(); s.maxLifetime > 0 && authTime != nil && now.Sub(*authTime) > s.maxLifetime {
+		return ErrSessionMaxLifetime
+	}
+	if s.idleTimeout > 0 && session.LastSeenAt != nil && now.Sub(*session.LastSeenAt) > s.idleTimeout {
+		return ErrSessionIdleTimeout
+	}
+	return nil
+}
+
+// updateLastSeen records that the session was used by this request. The
+// session is only saved when the idle timeout is enforced and the recorded
+// time is stale, to avoid writing the session on every request.
+func (s *storedSessionLoader) updateLastSeen(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) {
+	if s.idleTimeout <= 0 {
+		return
+	}
+
+	interval := sessionLastSeenInterval
+	if s.idleTimeout/2 < interval {
+		interval = s.idleTimeout / 2
+	}
+	if session.LastSeenAt != nil && session.Clock.Since(*session.LastSeenAt) < interval {
+		return
+	}
+
+	session.LastSeenAtNow()
+	if err := s.store.Save(rw, req, session); err != nil {
+		logger.PrintAuthf(session.Email, req, logger.AuthError, "error saving session: %v", err)
+	}
+}
+
+// sessionEndReason maps an error loading the session to the reason reported
+// to the user when they are sent to log in again
+func sessionEndReason(err error) string {
+	switch {
+	case errors.Is(err, ErrSessionIdleTimeout):
+		return SessionEndReasonIdleTimeout
+	case errors.Is(err, ErrSessionMaxLifetime):
+		return SessionEndReasonMaxLifetime
+	default:
+		return ""
+	}
+}
+
 // refreshSessionIfNeeded will attempt to refresh a session if the session
-// is older than the refresh period.
+// is older than the refresh period or its access token is about to expire.
 // Success or fail, we will then validate the session.
 func (s *storedSessionLoader) refreshSessionIfNeeded(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) error {
-	if !needsRefresh(s.refreshPeriod, session) {
+	if !s.needsRefresh(session) {
 		// Refresh is disabled or the session is not old enough, do nothing
 		return nil
 	}
 
+	if s.backgroundRefresh && !session.IsExpired() {
+		// The current session is still usable for this request
+		s.refreshSessionInBackground(req, session)
+		return nil
+	}
+
 	var lockObtained bool
 	ctx, cancel := context.WithTimeout(context.Background(), sessionRefreshObtainTimeout)
 	defer cancel()
@@ -179,15 +283,20 @@ func (s *storedSessionLoader) refreshSessionIfNeeded(rw http.ResponseWriter, req
 	// Loading from the session store creates a new lock in the session.
 	session.Lock = lock
 
-	if !needsRefresh(s.refreshPeriod, session) {
+	if !s.needsRefresh(session) {
 		// The session must have already been refreshed while we were waiting to
 		// obtain the lock.
 		return nil
 	}
 
 	// We are holding the lock and the session needs a refresh
-	logger.Printf("Refreshing session - User: %s; SessionAge: %s", session.User, session.Age())
+	logger.Printf("Refreshing session - User: %s; SessionAge: %s; ExpiresOn: %s", session.User, session.Age(), session.ExpiresOn)
 	if err := s.refreshSession(rw, req, session); err != nil {
+		// A rejected refresh token can never be used again, so the user
+		// must log in again rather than continue on the stale tokens.
+		if errors.Is(err, providers.ErrInvalidGrant) {
+			return err
+		}
 		// If a preemptive refresh fails, we still keep the session
 		// if validateSession succeeds.
 		logger.Errorf("Unable to refresh session: %v", err)
@@ -197,17 +306,93 @@ func (s *storedSessionLoader) refreshSessionIfNeeded(rw http.ResponseWriter, req
 	return s.validateSession(req.Context(), session)
 }
 
+// refreshSessionInBackground refreshes the session asynchronously while
+// holding its lock. If another request already holds the lock it is
+// refreshing the session and nothing more needs to be done.
+func (s *storedSessionLoader) refreshSessionInBackground(req *http.Request, session *sessionsapi.SessionState) {
+	err := session.ObtainLock(req.Context(), sessionRefreshLockDuration)
+	if err != nil {
+		if !errors.Is(err, sessionsapi.ErrLockNotObtained) {
+			logger.Errorf("error occurred while trying to obtain lock: %v", err)
+		}
+		return
+	}
+
+	// Detach from the request so the refresh can outlive it
+	bgReq := req.Clone(context.WithoutCancel(req.Context()))
+	lock := session.Lock
+
+	go func() {
+		defer func() {
+			if err := lock.Release(bgReq.Context()); err != nil {
+				logger.Errorf("unable to release lock: %v", err)
+			}
+		}()
+
+		// Reload the session in case it was changed underneath us.
+		freshSession, err := s.store.Load(bgReq)
+		if err != nil || freshSession == nil {
+			logger.Errorf("Unable to load session for background refresh: %v", err)
+			return
+		}
+		freshSession.Lock = lock
+		if !s.needsRefresh(freshSession) {
+			return
+		}
+
+		// The response has already been sent, so only the store is updated
+		rw := &discardResponseWriter{}
+		logger.Printf("Refreshing session in background - User: %s; SessionAge: %s; ExpiresOn: %s", freshSession.User, freshSession.Age(), freshSession.ExpiresOn)
+		err = s.refreshSession(rw, bgReq, freshSession)
+		if err != nil {
+			logger.Errorf("Unable to refresh session: %v", err)
+		}
+		if errors.Is(err, providers.ErrInvalidGrant) {
+			// The refresh token was rejected, the user has to log in again
+			if err := s.store.Clear(rw, bgReq); err != nil {
+				logger.Errorf("Error removing session: %v", err)
+			}
+			return
+		}
+
+		if err := s.validateSession(bgReq.Context(), freshSession); err != nil {
+			logger.Errorf("Session invalid after background refresh: %v, removing session", err)
+			if err := s.store.Clear(rw, bgReq); err != nil {
+				logger.Errorf("Error removing session: %v", err)
+			}
+		}
+	}()
+}
+
 // needsRefresh determines whether we should attempt to refresh a session or not.
-func needsRefresh(refreshPeriod time.Duration, session *sessionsapi.SessionState) bool {
+func (s *storedSessionLoader) needsRefresh(session *sessionsapi.SessionState) bool {
+	return needsRefreshByAge(s.refreshPeriod, session) || needsRefreshByExpiry(s.refreshBeforeExpiry, session)
+}
+
+// needsRefreshByAge checks whether the session is older than the refresh period
+func needsRefreshByAge(refreshPeriod time.Duration, session *sessionsapi.SessionState) bool {
 	return refreshPeriod > time.Duration(0) && session.Age() > refreshPeriod
 }
 
+// needsRefreshByExpiry checks whether the session's access token expires
+// within the given duration. Only sessions holding a refresh token can be
+// renewed before they expire.
+func needsRefreshByExpiry(beforeExpiry time.Duration, session *sessionsapi.SessionState) bool {
+	if beforeExpiry <= time.Duration(0) || session.RefreshToken == "" {
+		return false
+	}
+	if session.ExpiresOn == nil || session.ExpiresOn.IsZero() {
+		return false
+	}
+	return session.Clock.Now().Add(beforeExpiry).After(*session.ExpiresOn)
+}
+
 // refreshSession attempts to refresh the session with the provider
 // and will save the session if it was updated.
 func (s *storedSessionLoader) refreshSession(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) error {
 	refreshed, err := s.sessionRefresher(req.Context(), session)
 	if err != nil && !errors.Is(err, providers.ErrNotImplemented) {
-		return fmt.Errorf("error refreshing tokens: %v", err)
+		return fmt.Errorf("error refreshing tokens: %w", err)
 	}
 
 	// HACK:
@@ -228,6 +413,7 @@ func (s *storedSessionLoader) refreshSession(rw http.ResponseWriter, req *http.R
 	// If we refreshed, update the `CreatedAt` time to reset the refresh timer
 	// (In case underlying provider implementations forget)
 	session.CreatedAtNow()
+	session.RefreshedAtNow()
 
 	// Because the session was refreshed, make sure to save it
 	err = s.store.Save(rw, req, session)
@@ -252,3 +438,22 @@ func (s *storedSessionLoader) validateSession(ctx context.Context, session *sess
 
 	return nil
 }
+
+// discardResponseWriter is used to save sessions once the response for the
+// request that triggered the save has already been written
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(_ int) {}
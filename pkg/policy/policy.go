@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	celast "github.com/google/cel-go/common/ast"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/parser"
+)
+
+// Input is what a policy is evaluated against
+type Input struct {
+	User     string
+	Email    string
+	Groups   []string
+	Claims   map[string]interface{}
+	ClientIP string
+
+	Method  string
+	Host    string
+	Path    string
+	Headers map[string]string
+}
+
+// Policy is a compiled CEL expression that has to evaluate to true for a
+// request to be allowed.
+//
+// The expression can use the variables `user`, `email`, `groups`, `claims`,
+// `client_ip` and `request`, which holds the `method`, `host`, `path` and
+// `headers` of the request. Header names are lower case.
+type Policy struct {
+	Expression string
+	conditions []condition
+}
+
+// condition is one of the top level `&&` operands of a policy, evaluated on
+// its own so that a denial can name the condition that wasn't met
+type condition struct {
+	source  string
+	program cel.Program
+}
+
+func newEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("user", cel.StringType),
+		cel.Variable("email", cel.StringType),
+		cel.Variable("groups", cel.ListType(cel.StringType)),
+		cel.Variable("claims", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("client_ip", cel.StringType),
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+	)
+}
+
+// New compiles a policy expression. The expression must evaluate to a bool.
+func New(expression string) (*Policy, error) {
+	env, err := newEnv()
+	if err != nil {
+		return nil, fmt.Errorf("error creating policy environment: %v", err)
+	}
+
+	checked, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if checked.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("policy must evaluate to a bool, not %s", checked.OutputType())
+	}
+
+	p := &Policy{Expression: expression}
+	native := checked.NativeRep()
+	for _, expr := range conjuncts(native.Expr()) {
+		source, err := parser.Unparse(expr, native.SourceInfo())
+		if err != nil {
+			return nil, fmt.Errorf("error splitting policy: %v", err)
+		}
+		ast, issues := env.Compile(source)
+		if issues != nil && issues.Err() != nil {
+			return nil, issues.Err()
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("error creating policy program: %v", err)
+		}
+		p.conditions = append(p.conditions, condition{source: source, program: program})
+	}
+	return p, nil
+}
+
+// conjuncts flattens the top level `&&` operations of an expression
+func conjuncts(expr celast.Expr) []celast.Expr {
+	if expr.Kind() != celast.CallKind || expr.AsCall().FunctionName() != operators.LogicalAnd {
+		return []celast.Expr{expr}
+	}
+	var exprs []celast.Expr
+	for _, arg := range expr.AsCall().Args() {
+		exprs = append(exprs, conjuncts(arg)...)
+	}
+	return exprs
+}
+
+// Evaluate reports whether the input is allowed by the policy. When it
+// isn't, the reason names the condition that wasn't met.
+func (p *Policy) Evaluate(in Input) (bool, string) {
+	activation := in.activation()
+	for _, c := range p.conditions {
+		out, _, err := c.program.Eval(activation)
+		if err != nil {
+			return false, fmt.Sprintf("error evaluating %s: %v", c.source, err)
+		}
+		if allowed, ok := out.Value().(bool); !ok || !allowed {
+			return false, fmt.Sprintf("%s is false", c.source)
+		}
+	}
+	return true, ""
+}
+
+func (in Input) activation() map[string]interface{} {
+	groups := in.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	claims := in.Claims
+	if claims == nil {
+		claims = map[string]interface{}{}
+	}
+	headers := make(map[string]string, len(in.Headers))
+	for name, value := range in.Headers {
+		headers[strings.ToLower(name)] = value
+	}
+
+	return map[string]interface{}{
+		"user":      in.User,
+		"email":     in.Email,
+		"groups":    groups,
+		"claims":    claims,
+		"client_ip": in.ClientIP,
+		"request": map[string]interface{}{
+			"method":  in.Method,
+			"host":    in.Host,
+			"path":    in.Path,
+			"headers": headers,
+		},
+	}
+}
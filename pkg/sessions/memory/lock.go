@@ -0,0 +1,66 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"oidc/pkg/apis/sessions"
+	"oidc/pkg/clock"
+)
+
+// Lock is an in-memory implementation of sessions.Lock
+type Lock struct {
+	mu      sync.Mutex
+	expires time.Time
+	clock   *clock.Clock
+}
+
+// Obtain obtains the lock if it is not currently held.
+// Otherwise it will return sessions.ErrLockNotObtained
+func (l *Lock) Obtain(_ context.Context, expiration time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if now.Before(l.expires) {
+		return sessions.ErrLockNotObtained
+	}
+	l.expires = now.Add(expiration)
+	return nil
+}
+
+// Peek returns true if the lock is currently held
+func (l *Lock) Peek(_ context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.clock.Now().Before(l.expires), nil
+}
+
+// Refresh extends the expiration of a held lock.
+// Otherwise it will return sessions.ErrNotLocked
+func (l *Lock) Refresh(_ context.Context, expiration time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if !now.Before(l.expires) {
+		return sessions.ErrNotLocked
+	}
+	l.expires = now.Add(expiration)
+	return nil
+}
+
+// Release releases a held lock.
+// Otherwise it will return sessions.ErrNotLocked
+func (l *Lock) Release(_ context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.clock.Now().Before(l.expires) {
+		return sessions.ErrNotLocked
+	}
+	l.expires = time.Time{}
+	return nil
+}
@@ -0,0 +1,196 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"oidc/pkg/apis/options"
+	"oidc/pkg/apis/sessions"
+	"oidc/pkg/clock"
+	"oidc/pkg/sessions/persistence"
+)
+
+// entry is a stored value with the time it stops being valid
+type entry struct {
+	data    []byte
+	expires time.Time
+}
+
+// expired reports whether the entry is no longer valid at now
+func (e entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// Expired entries, index references and unused locks are swept this often.
+// Entries are otherwise only removed when they are loaded.
+const sweepInterval = 5 * time.Minute
+
+// Ensure SessionStore implements the interface
+var _ persistence.IndexedStore = &SessionStore{}
+
+// SessionStore is an in-memory implementation of persistence.IndexedStore.
+// Sessions only live as long as the process and are not shared between
+// replicas, so it is suited to single instance deployments.
+type SessionStore struct {
+	mu      sync.Mutex
+	entries map[string]entry
+	indexes map[string]map[string]time.Time
+	locks   map[string]*Lock
+
+	lastSweep time.Time
+
+	Clock clock.Clock
+}
+
+// NewMemorySessionStore initialises a new instance of the SessionStore and wraps
+// it in a persistence.Manager
+func NewMemorySessionStore(_ *options.SessionOptions, cookieOpts *options.Cookie) (sessions.SessionStore, error) {
+	return persistence.NewManager(NewStore(), cookieOpts), nil
+}
+
+// NewStore creates an empty in-memory SessionStore
+func NewStore() *SessionStore {
+	return &SessionStore{
+		entries: make(map[string]entry),
+		indexes: make(map[string]map[string]time.Time),
+		locks:   make(map[string]*Lock),
+	}
+}
+
+// Save stores the session under key until exp has elapsed
+func (s *SessionStore) Save(_ context.Context, key string, value []byte, exp time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepIfDue()
+	s.entries[key] = entry{
+		data:    value,
+		expires: s.expiry(exp),
+	}
+	return nil
+}
+
+// Load returns the session stored under key
+func (s *SessionStore) Load(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || e.expired(s.Clock.Now()) {
+		delete(s.entries, key)
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+	return e.data, nil
+}
+
+// Clear removes the session stored under key
+func (s *SessionStore) Clear(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	delete(s.locks, key)
+	return nil
+}
+
+// Lock returns the lock for the session stored under key
+func (s *SessionStore) Lock(key string) sessions.Lock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l, ok := s.locks[key]; ok {
+		return l
+	}
+	l := &Lock{clock: &s.Clock}
+	s.locks[key] = l
+	return l
+}
+
+// VerifyConnection always returns no-error, as there's no connection
+// in this store
+func (s *SessionStore) VerifyConnection(_ context.Context) error {
+	return nil
+}
+
+// AddToIndex records key under index until exp has elapsed
+func (s *SessionStore) AddToIndex(_ context.Context, index, key string, exp time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepIfDue()
+	keys, ok := s.indexes[index]
+	if !ok {
+		keys = make(map[string]time.Time)
+		s.indexes[index] = keys
+	}
+	keys[key] = s.expiry(exp)
+	return nil
+}
+
+// IndexedKeys returns the keys recorded under index that still hold a
+// session. Stale references are dropped as they are found.
+func (s *SessionStore) IndexedKeys(_ context.Context, index string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Clock.Now()
+	var result []string
+	for key, expires := range s.indexes[index] {
+		e, ok := s.entries[key]
+		if !ok || e.expired(now) || (entry{expires: expires}).expired(now) {
+			delete(s.indexes[index], key)
+			continue
+		}
+		result = append(result, key)
+	}
+	if len(s.indexes[index]) == 0 {
+		delete(s.indexes, index)
+	}
+	return result, nil
+}
+
+// sweepIfDue removes expired entries and index references, and locks of
+// removed entries that aren't held, when sweepInterval has passed since the
+// last sweep. The caller must hold s.mu.
+func (s *SessionStore) sweepIfDue() {
+	now := s.Clock.Now()
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, e := range s.entries {
+		if e.expired(now) {
+			delete(s.entries, key)
+		}
+	}
+	for index, keys := range s.indexes {
+		for key, expires := range keys {
+			if _, ok := s.entries[key]; !ok || (entry{expires: expires}).expired(now) {
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(s.indexes, index)
+		}
+	}
+	for key, l := range s.locks {
+		if _, ok := s.entries[key]; ok {
+			continue
+		}
+		if held, _ := l.Peek(context.Background()); !held {
+			delete(s.locks, key)
+		}
+	}
+}
+
+// expiry converts a relative expiration into an absolute one. A zero
+// duration never expires.
+func (s *SessionStore) expiry(exp time.Duration) time.Time {
+	if exp <= 0 {
+		return time.Time{}
+	}
+	return s.Clock.Now().Add(exp)
+}
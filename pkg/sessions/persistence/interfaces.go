@@ -0,0 +1,31 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"oidc/pkg/apis/sessions"
+)
+
+// Store is used for persistent session stores (IE not Cookie)
+// Implementing this interface allows it to easily use the persistence.Manager
+// for session ticket + encryption details.
+type Store interface {
+	Save(context.Context, string, []byte, time.Duration) error
+	Load(context.Context, string) ([]byte, error)
+	Clear(context.Context, string) error
+	Lock(key string) sessions.Lock
+	VerifyConnection(context.Context) error
+}
+
+// IndexedStore is a Store that can also record which keys belong to a
+// secondary index value, such as the OIDC `sid` or `sub` of the session.
+// Stores implementing it allow the Manager to find sessions without the
+// user's ticket cookie.
+type IndexedStore interface {
+	Store
+	// AddToIndex records key under index until exp has elapsed
+	AddToIndex(ctx context.Context, index, key string, exp time.Duration) error
+	// IndexedKeys returns the keys currently recorded under index
+	IndexedKeys(ctx context.Context, index string) ([]string, error)
+}
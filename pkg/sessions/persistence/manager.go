@@ -0,0 +1,338 @@
+package persistence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"oidc/pkg/apis/options"
+	"oidc/pkg/apis/sessions"
+	"oidc/pkg/encryption"
+)
+
+const (
+	sessionIDIndexPrefix = "sid:"
+	subjectIndexPrefix   = "sub:"
+	userIndexPrefix      = "user:"
+	emailIndexPrefix     = "email:"
+	dataKeyPrefix        = "data:"
+)
+
+// ErrIndexNotSupported is returned when a session lookup by index is
+// attempted against a Store that doesn't implement IndexedStore
+var ErrIndexNotSupported = errors.New("session store does not support indexes")
+
+// Ensure Manager implements the interfaces
+var (
+	_ sessions.SessionStore = &Manager{}
+	_ sessions.SessionIndex = &Manager{}
+	_ sessions.DataStore    = &Manager{}
+)
+
+// Manager wraps a Store and handles the implementation details of the
+// sessions.SessionStore with its use of session tickets
+type Manager struct {
+	Store   Store
+	Options *options.Cookie
+}
+
+// NewManager creates a Manager that can wrap a Store and manage the
+// sessions.SessionStore implementation details
+func NewManager(store Store, cookieOpts *options.Cookie) *Manager {
+	return &Manager{
+		Store:   store,
+		Options: cookieOpts,
+	}
+}
+
+// Save saves a session in a persistent Store. Save will generate (or reuse an
+// existing) ticket which manages unique per session encryption & retrieval
+// from the persistent data store.
+func (m *Manager) Save(rw http.ResponseWriter, req *http.Request, s *sessions.SessionState) error {
+	if s.CreatedAt == nil || s.CreatedAt.IsZero() {
+		s.CreatedAtNow()
+	}
+
+	tckt, err := decodeTicketFromRequest(req, m.Options)
+	if err != nil {
+		tckt, err = newTicket(m.Options)
+		if err != nil {
+			return fmt.Errorf("error creating a session ticket: %v", err)
+		}
+	}
+
+	err = tckt.saveSession(s, func(key string, val []byte, exp time.Duration) error {
+		return m.Store.Save(req.Context(), key, val, exp)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := m.index(req.Context(), tckt, s); err != nil {
+		return fmt.Errorf("error indexing session: %v", err)
+	}
+
+	return tckt.setCookie(rw, req, s)
+}
+
+// Load reads sessions.SessionState information from a session store. It will
+// use the session ticket from the http.Request's cookie.
+func (m *Manager) Load(req *http.Request) (*sessions.SessionState, error) {
+	tckt, err := decodeTicketFromRequest(req, m.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return tckt.loadSession(
+		func(key string) ([]byte, error) {
+			return m.Store.Load(req.Context(), key)
+		},
+		m.Store.Lock,
+	)
+}
+
+// Clear clears any saved session information for a given ticket cookie.
+// Then it clears all session data for that ticket in the Store.
+func (m *Manager) Clear(rw http.ResponseWriter, req *http.Request) error {
+	tckt, err := decodeTicketFromRequest(req, m.Options)
+	if err != nil {
+		// Always clear the cookie, even when we can't load a cookie from
+		// the request
+		tckt = &ticket{
+			options: m.Options,
+		}
+		tckt.clearCookie(rw, req)
+		// Don't raise an error if we didn't have a Cookie
+		if errors.Is(err, http.ErrNoCookie) {
+			return nil
+		}
+		return fmt.Errorf("error decoding ticket to clear session: %v", err)
+	}
+
+	tckt.clearCookie(rw, req)
+	return tckt.clearSession(func(key string) error {
+		return m.ClearByID(req.Context(), key)
+	})
+}
+
+// VerifyConnection validates the underlying store is ready and connected
+func (m *Manager) VerifyConnection(ctx context.Context) error {
+	return m.Store.VerifyConnection(ctx)
+}
+
+// SaveData stores data that isn't a session in the Store, apart from the
+// session keys
+func (m *Manager) SaveData(ctx context.Context, key string, value []byte, exp time.Duration) error {
+	return m.Store.Save(ctx, dataKeyPrefix+key, value, exp)
+}
+
+// LoadData loads data stored with SaveData
+func (m *Manager) LoadData(ctx context.Context, key string) ([]byte, error) {
+	return m.Store.Load(ctx, dataKeyPrefix+key)
+}
+
+// ClearData removes data stored with SaveData
+func (m *Manager) ClearData(ctx context.Context, key string) error {
+	return m.Store.Clear(ctx, dataKeyPrefix+key)
+}
+
+// ClearBySessionID clears every stored session that was created within the
+// given IdP session.
+func (m *Manager) ClearBySessionID(ctx context.Context, sid string) (int, error) {
+	return m.clearIndex(ctx, sessionIDIndexPrefix+sid)
+}
+
+// ClearBySubject clears every stored session belonging to the given subject.
+func (m *Manager) ClearBySubject(ctx context.Context, sub string) (int, error) {
+	return m.clearIndex(ctx, subjectIndexPrefix+sub)
+}
+
+// ListByUser describes every stored session whose subject, user or email
+// matches the given user.
+func (m *Manager) ListByUser(ctx context.Context, user string) ([]sessions.SessionInfo, error) {
+	store, ok := m.Store.(IndexedStore)
+	if !ok {
+		return nil, ErrIndexNotSupported
+	}
+
+	seen := make(map[string]struct{})
+	infos := []sessions.SessionInfo{}
+	for _, index := range []string{subjectIndexPrefix + user, userIndexPrefix + user, emailIndexPrefix + user} {
+		keys, err := store.IndexedKeys(ctx, index)
+		if err != nil {
+			return nil, fmt.Errorf("error loading sessions for %s: %v", index, err)
+		}
+		for _, key := range keys {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+
+			record, err := m.loadRecord(ctx, key)
+			if err != nil {
+				// The session may have been removed since the index was read
+				continue
+			}
+			infos = append(infos, record.Info)
+		}
+	}
+	return infos, nil
+}
+
+// LoadByID loads the stored session with the given ID. The ticket secret
+// needed to decrypt it is recovered from the session's record.
+func (m *Manager) LoadByID(ctx context.Context, id string) (*sessions.SessionState, error) {
+	record, err := m.loadRecord(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := m.makeCipher()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := c.Decrypt(record.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting ticket secret: %v", err)
+	}
+
+	tckt := &ticket{
+		id:      id,
+		secret:  secret,
+		options: m.Options,
+	}
+	return tckt.loadSession(
+		func(key string) ([]byte, error) {
+			return m.Store.Load(ctx, key)
+		},
+		m.Store.Lock,
+	)
+}
+
+// ClearByID clears the stored session with the given ID
+func (m *Manager) ClearByID(ctx context.Context, id string) error {
+	if err := m.Store.Clear(ctx, id); err != nil {
+		return err
+	}
+	return m.Store.Clear(ctx, recordKey(id))
+}
+
+// index records the ticket ID against the session's `sid`, `sub`, user and
+// email when the underlying Store supports it, and stores the session's
+// record so it can later be described and loaded without the ticket cookie.
+func (m *Manager) index(ctx context.Context, tckt *ticket, s *sessions.SessionState) error {
+	store, ok := m.Store.(IndexedStore)
+	if !ok {
+		return nil
+	}
+
+	if err := m.saveRecord(ctx, tckt, s); err != nil {
+		return err
+	}
+
+	for _, index := range []struct {
+		prefix string
+		value  string
+	}{
+		{sessionIDIndexPrefix, s.SessionID},
+		{subjectIndexPrefix, s.Subject},
+		{userIndexPrefix, s.User},
+		{emailIndexPrefix, s.Email},
+	} {
+		if index.value == "" {
+			continue
+		}
+		if err := store.AddToIndex(ctx, index.prefix+index.value, tckt.id, m.Options.Expire); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearIndex clears all sessions recorded under the given index
+func (m *Manager) clearIndex(ctx context.Context, index string) (int, error) {
+	store, ok := m.Store.(IndexedStore)
+	if !ok {
+		return 0, ErrIndexNotSupported
+	}
+
+	keys, err := store.IndexedKeys(ctx, index)
+	if err != nil {
+		return 0, fmt.Errorf("error loading sessions for %s: %v", index, err)
+	}
+
+	for _, key := range keys {
+		if err := m.ClearByID(ctx, key); err != nil {
+			return 0, fmt.Errorf("error clearing session %s: %v", key, err)
+		}
+	}
+	return len(keys), nil
+}
+
+// saveRecord stores the session's record next to the session
+func (m *Manager) saveRecord(ctx context.Context, tckt *ticket, s *sessions.SessionState) error {
+	c, err := m.makeCipher()
+	if err != nil {
+		return err
+	}
+	secret, err := c.Encrypt(tckt.secret)
+	if err != nil {
+		return fmt.Errorf("error encrypting ticket secret: %v", err)
+	}
+
+	record, err := json.Marshal(sessionRecord{
+		Info: sessions.SessionInfo{
+			ID:          tckt.id,
+			User:        s.User,
+			Email:       s.Email,
+			CreatedAt:   s.AuthTime(),
+			ExpiresOn:   s.ExpiresOn,
+			RefreshedAt: s.RefreshedAt,
+			ClientIP:    s.ClientIP,
+			ProviderID:  s.ProviderID,
+		},
+		Secret: secret,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshalling session record: %v", err)
+	}
+	return m.Store.Save(ctx, recordKey(tckt.id), record, m.Options.Expire)
+}
+
+// loadRecord loads the record of the session stored under id
+func (m *Manager) loadRecord(ctx context.Context, id string) (*sessionRecord, error) {
+	data, err := m.Store.Load(ctx, recordKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session record: %v", err)
+	}
+
+	var record sessionRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("error unmarshalling session record: %v", err)
+	}
+	return &record, nil
+}
+
+// makeCipher makes an AES-GCM cipher out of the cookie secret
+func (m *Manager) makeCipher() (encryption.Cipher, error) {
+	return encryption.NewGCMCipher(encryption.SecretBytes(m.Options.Secret))
+}
+
+// sessionRecord is stored next to each session so that it can be described
+// and loaded without the user's ticket cookie, which revoking its tokens at
+// the provider from the admin API needs. It never holds tokens. The ticket
+// secret is encrypted with the cookie secret, so a copy of the store alone
+// still can't decrypt the sessions, but one together with the proxy's
+// configuration can.
+type sessionRecord struct {
+	Info   sessions.SessionInfo `json:"info"`
+	Secret []byte               `json:"secret"`
+}
+
+// recordKey returns the Store key of a session's record
+func recordKey(id string) string {
+	return id + ":record"
+}
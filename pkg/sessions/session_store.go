@@ -7,6 +7,7 @@ import (
 	"oidc/pkg/apis/sessions"
 
 	"oidc/pkg/sessions/cookie"
+	"oidc/pkg/sessions/memory"
 )
 
 // NewSessionStore creates a SessionStore from the provided configuration
@@ -14,6 +15,8 @@ func NewSessionStore(opts *options.SessionOptions, cookieOpts *options.Cookie) (
 	switch opts.Type {
 	case options.CookieSessionStoreType:
 		return cookie.NewCookieSessionStore(opts, cookieOpts)
+	case options.MemorySessionStoreType:
+		return memory.NewMemorySessionStore(opts, cookieOpts)
 	default:
 		return nil, fmt.Errorf("unknown session store type '%s'", opts.Type)
 	}
@@ -0,0 +1,31 @@
+package validation
+
+import (
+	"fmt"
+	"net/url"
+
+	"oidc/pkg/apis/options"
+)
+
+func validateAuthzCallout(o *options.Options) []string {
+	msgs := []string{}
+
+	if o.AuthzCallout.URL == "" {
+		return msgs
+	}
+
+	if u, err := url.Parse(o.AuthzCallout.URL); err != nil || !u.IsAbs() {
+		msgs = append(msgs, fmt.Sprintf("authz_callout_url (%q) must be an absolute URL", o.AuthzCallout.URL))
+	}
+	if o.AuthzCallout.Timeout <= 0 {
+		msgs = append(msgs, fmt.Sprintf("authz_callout_timeout (%q) must be positive", o.AuthzCallout.Timeout.String()))
+	}
+	if o.AuthzCallout.CacheTTL < 0 {
+		msgs = append(msgs, fmt.Sprintf("authz_callout_cache_ttl (%q) must not be negative", o.AuthzCallout.CacheTTL.String()))
+	}
+	if o.AuthzCallout.CacheTTL > 0 && o.AuthzCallout.CacheSize <= 0 {
+		msgs = append(msgs, fmt.Sprintf("authz_callout_cache_size (%d) must be positive", o.AuthzCallout.CacheSize))
+	}
+
+	return msgs
+}
@@ -0,0 +1,33 @@
+package validation
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"oidc/pkg/apis/options"
+)
+
+func validateDPoPRoutes(o *options.Options) []string {
+	msgs := []string{}
+
+	if len(o.DPoPRoutes) > 0 && len(o.Providers) > 0 && !o.Providers[0].DPoP {
+		msgs = append(msgs, "dpop_routes requires dpop to be enabled")
+	}
+
+	for i, route := range o.DPoPRoutes {
+		if route.Path == "" {
+			msgs = append(msgs, fmt.Sprintf("dpop_routes[%d] is missing a path", i))
+		} else if _, err := regexp.Compile(route.Path); err != nil {
+			msgs = append(msgs, fmt.Sprintf("dpop_routes[%d] path (%q) is not a valid regular expression: %v", i, route.Path, err))
+		}
+
+		if route.URL != "" {
+			if u, err := url.Parse(route.URL); err != nil || !u.IsAbs() || u.RawQuery != "" || u.Fragment != "" {
+				msgs = append(msgs, fmt.Sprintf("dpop_routes[%d] url (%q) must be an absolute base URL without a query or fragment", i, route.URL))
+			}
+		}
+	}
+
+	return msgs
+}
@@ -0,0 +1,43 @@
+package validation
+
+import (
+	"fmt"
+	"net/url"
+
+	"oidc/pkg/apis/options"
+)
+
+func validateKubernetes(o *options.Options) []string {
+	msgs := []string{}
+
+	k := o.Kubernetes
+	if k.UpstreamURL == "" {
+		return msgs
+	}
+
+	if u, err := url.Parse(k.UpstreamURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		msgs = append(msgs, fmt.Sprintf("kubernetes_upstream_url (%q) must be an absolute http or https URL", k.UpstreamURL))
+	}
+	if k.TokenFile == "" && k.ClientCertFile == "" {
+		msgs = append(msgs, "kubernetes_upstream_url requires kubernetes_token_file or kubernetes_client_cert_file to authenticate to the upstream")
+	}
+	if (k.ClientCertFile == "") != (k.ClientKeyFile == "") {
+		msgs = append(msgs, "kubernetes_client_cert_file and kubernetes_client_key_file must be set together")
+	}
+	for key, claim := range k.ExtraClaims {
+		if key == "" || claim == "" {
+			msgs = append(msgs, fmt.Sprintf("kubernetes_extra_claims (%q: %q) must map a non-empty key to a claim", key, claim))
+		}
+	}
+
+	// The upstream only accepts the proxy's own credentials, so tokens
+	// passed upstream on behalf of the user would be discarded
+	if len(o.TokenExchangeRoutes) > 0 {
+		msgs = append(msgs, "token_exchange_routes can't be used with kubernetes_upstream_url")
+	}
+	if len(o.DPoPRoutes) > 0 {
+		msgs = append(msgs, "dpop_routes can't be used with kubernetes_upstream_url")
+	}
+
+	return msgs
+}
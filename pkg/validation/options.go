@@ -10,13 +10,22 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/util"
 	"oidc/pkg/apis/options"
+	"oidc/pkg/ip"
 )
 
 // Validate checks that required options are set and validates those that they
 // are of the correct format
 func Validate(o *options.Options) error {
 	msgs := validateCookie(o.Cookie)
+	msgs = append(msgs, validateSessions(o)...)
 	msgs = append(msgs, validateProviders(o)...)
+	msgs = append(msgs, validateStepUpRoutes(o)...)
+	msgs = append(msgs, validateTokenExchangeRoutes(o)...)
+	msgs = append(msgs, validateDPoPRoutes(o)...)
+	msgs = append(msgs, validatePreserveRequest(o)...)
+	msgs = append(msgs, validatePolicyRoutes(o)...)
+	msgs = append(msgs, validateAuthzCallout(o)...)
+	msgs = append(msgs, validateKubernetes(o)...)
 
 	if o.SSLInsecureSkipVerify {
 		insecureTransport := &http.Transport{
@@ -50,6 +59,52 @@ func Validate(o *options.Options) error {
 		logger.Print("WARNING: no explicit redirect URL: redirects will default to insecure HTTP")
 	}
 
+	if o.ProtectedResource != "" {
+		if u, err := url.Parse(o.ProtectedResource); err != nil || !u.IsAbs() || u.RawQuery != "" || u.Fragment != "" {
+			msgs = append(msgs, fmt.Sprintf("protected_resource (%q) must be an absolute URL without a query or fragment", o.ProtectedResource))
+		}
+	}
+
+	if (o.BFF.TokenEndpoint || o.BFF.EnforceCSRFHeader) && o.BFF.CSRFHeader == "" {
+		msgs = append(msgs, "missing setting: bff_csrf_header is required by bff_token_endpoint and bff_enforce_csrf_header")
+	}
+
+	switch o.FormPostCSRF {
+	case options.FormPostCSRFInterstitial:
+	case options.FormPostCSRFSameSiteNone:
+		if !o.Cookie.Secure {
+			msgs = append(msgs, "form_post_csrf samesite_none requires cookie_secure, browsers reject SameSite=None cookies that aren't secure")
+		}
+	default:
+		msgs = append(msgs, fmt.Sprintf("form_post_csrf (%q) must be one of ['interstitial', 'samesite_none']", o.FormPostCSRF))
+	}
+
+	if o.DeviceFlow.Enabled {
+		switch o.DeviceFlow.Response {
+		case options.DeviceFlowResponseTicket:
+		case options.DeviceFlowResponseBearer:
+			if !o.BearerTokenIntrospection {
+				msgs = append(msgs, "device_flow_response bearer requires bearer_token_introspection, the proxy can't accept the access token it hands out otherwise")
+			}
+		default:
+			msgs = append(msgs, fmt.Sprintf("device_flow_response (%q) must be one of ['ticket', 'bearer']", o.DeviceFlow.Response))
+		}
+		if o.DeviceFlow.MaxPending <= 0 {
+			msgs = append(msgs, fmt.Sprintf("device_flow_max_pending (%d) must be positive", o.DeviceFlow.MaxPending))
+		}
+		if o.DeviceFlow.MaxPendingPerClient <= 0 {
+			msgs = append(msgs, fmt.Sprintf("device_flow_max_pending_per_client (%d) must be positive", o.DeviceFlow.MaxPendingPerClient))
+		}
+	}
+
+	if o.ReverseProxy {
+		parser, err := ip.GetRealClientIPParser(o.RealClientIPHeader)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("real_client_ip_header (%s) not accepted parameter value: %v", o.RealClientIPHeader, err))
+		}
+		o.SetRealClientIPParser(parser)
+	}
+
 	if len(msgs) != 0 {
 		return fmt.Errorf("invalid configuration:\n  %s",
 			strings.Join(msgs, "\n  "))
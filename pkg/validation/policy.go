@@ -0,0 +1,29 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+
+	"oidc/pkg/apis/options"
+	"oidc/pkg/policy"
+)
+
+func validatePolicyRoutes(o *options.Options) []string {
+	msgs := []string{}
+
+	for i, route := range o.PolicyRoutes {
+		if route.Path == "" {
+			msgs = append(msgs, fmt.Sprintf("policy_routes[%d] is missing a path", i))
+		} else if _, err := regexp.Compile(route.Path); err != nil {
+			msgs = append(msgs, fmt.Sprintf("policy_routes[%d] path (%q) is not a valid regular expression: %v", i, route.Path, err))
+		}
+
+		if route.Policy == "" {
+			msgs = append(msgs, fmt.Sprintf("policy_routes[%d] is missing a policy", i))
+		} else if _, err := policy.New(route.Policy); err != nil {
+			msgs = append(msgs, fmt.Sprintf("policy_routes[%d] policy (%q) is invalid: %v", i, route.Policy, err))
+		}
+	}
+
+	return msgs
+}
@@ -0,0 +1,38 @@
+package validation
+
+import (
+	"fmt"
+
+	"oidc/pkg/apis/options"
+)
+
+// maxCookiePostBody is the largest body that still fits into a cookie once
+// it is encrypted and encoded
+const maxCookiePostBody = 2048
+
+func validatePreserveRequest(o *options.Options) []string {
+	msgs := []string{}
+
+	if !o.PreserveRequest.PostBody {
+		return msgs
+	}
+
+	if o.PreserveRequest.PostBodyLimit <= 0 {
+		msgs = append(msgs, fmt.Sprintf("preserve_post_body_limit (%d) must be positive", o.PreserveRequest.PostBodyLimit))
+	}
+
+	switch o.PreserveRequest.PostBodyStore {
+	case options.PreserveStoreCookie:
+		if o.PreserveRequest.PostBodyLimit > maxCookiePostBody {
+			msgs = append(msgs, fmt.Sprintf("preserve_post_body_limit (%d) must be at most %d with the cookie store, browsers drop larger cookies", o.PreserveRequest.PostBodyLimit, maxCookiePostBody))
+		}
+	case options.PreserveStoreSession:
+		if o.Session.Type == options.CookieSessionStoreType {
+			msgs = append(msgs, "preserve_post_body_store session requires a server side session store")
+		}
+	default:
+		msgs = append(msgs, fmt.Sprintf("preserve_post_body_store (%q) must be one of ['cookie', 'session']", o.PreserveRequest.PostBodyStore))
+	}
+
+	return msgs
+}
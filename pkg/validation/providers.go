@@ -23,6 +23,16 @@ func validateProviders(o *options.Options) []string {
 		msgs = append(msgs, validateProvider(provider, providerIDs)...)
 	}
 
+	if o.BearerTokenIntrospection && len(o.Providers) > 0 && o.Providers[0].Introspection.URL == "" {
+		msgs = append(msgs, "bearer_token_introspection requires introspection_url")
+	}
+
+	// Sessions in cookies outlive a generated DPoP key and are served by
+	// every instance, each with a key of its own
+	if len(o.Providers) > 0 && o.Providers[0].DPoP && o.Providers[0].DPoPKeyFile == "" && o.Session.Type != options.MemorySessionStoreType {
+		msgs = append(msgs, "dpop requires dpop_key_file unless session_store_type is memory, tokens bound to a generated key can't be used after a restart or by other instances")
+	}
+
 	return msgs
 }
 
@@ -43,8 +53,10 @@ func validateProvider(provider options.Provider, providerIDs map[string]struct{}
 		msgs = append(msgs, "provider missing setting: client-id")
 	}
 
+	msgs = append(msgs, validateClientAuth(provider)...)
+
 	// login.gov uses a signed JWT to authenticate, not a client-secret
-	if provider.Type != "login.gov" {
+	if provider.Type != "login.gov" && usesClientSecret(provider) {
 		if provider.ClientSecret == "" && provider.ClientSecretFile == "" {
 			msgs = append(msgs, "missing setting: client-secret or client-secret-file")
 		}
@@ -56,5 +68,69 @@ func validateProvider(provider options.Provider, providerIDs map[string]struct{}
 		}
 	}
 
+	if provider.PushedAuthorizationRequests && provider.PushedAuthorizationRequestURL == "" && provider.OIDCConfig.SkipDiscovery {
+		msgs = append(msgs, "pushed_authorization_requests requires pushed_authorization_request_url when OIDC discovery is skipped")
+	}
+
+	switch provider.ResponseMode {
+	case "", options.ResponseModeQuery, options.ResponseModeFormPost:
+	default:
+		msgs = append(msgs, fmt.Sprintf("response_mode (%q) must be one of ['query', 'form_post']", provider.ResponseMode))
+	}
+
+	if provider.SignedRequestObject && provider.JWTKey == "" && provider.JWTKeyFile == "" {
+		msgs = append(msgs, "signed_request_object requires jwt_key or jwt_key_file")
+	}
+	if provider.JARM && provider.OIDCConfig.JwksURL == "" && provider.OIDCConfig.SkipDiscovery {
+		msgs = append(msgs, "jarm requires oidc_jwks_url when OIDC discovery is skipped")
+	}
+
+	if provider.Introspection.CacheTTL < 0 {
+		msgs = append(msgs, "introspection_cache_ttl must not be negative")
+	}
+
+	if provider.OIDCConfig.RefreshRetries < 0 {
+		msgs = append(msgs, "oidc_refresh_retries must not be negative")
+	}
+	if provider.OIDCConfig.RefreshTokenGracePeriod < 0 {
+		msgs = append(msgs, "oidc_refresh_token_grace_period must not be negative")
+	}
+
 	return msgs
 }
+
+func validateClientAuth(provider options.Provider) []string {
+	msgs := []string{}
+
+	switch provider.TokenEndpointAuthMethod {
+	case "", options.ClientSecretBasicAuthMethod, options.ClientSecretPostAuthMethod:
+	case options.PrivateKeyJWTAuthMethod:
+		if provider.JWTKey == "" && provider.JWTKeyFile == "" {
+			msgs = append(msgs, "token_endpoint_auth_method private_key_jwt requires jwt_key or jwt_key_file")
+		}
+		if provider.JWTKey == "" && provider.JWTKeyFile != "" {
+			if _, err := os.ReadFile(provider.JWTKeyFile); err != nil {
+				msgs = append(msgs, "could not read jwt key file: "+provider.JWTKeyFile)
+			}
+		}
+	case options.TLSClientAuthMethod, options.SelfSignedTLSClientAuthMethod:
+		if provider.TLSClientCertFile == "" || provider.TLSClientKeyFile == "" {
+			msgs = append(msgs, fmt.Sprintf("token_endpoint_auth_method %s requires tls_client_cert_file and tls_client_key_file", provider.TokenEndpointAuthMethod))
+		}
+	default:
+		msgs = append(msgs, fmt.Sprintf("token_endpoint_auth_method (%q) must be one of ['client_secret_basic', 'client_secret_post', 'private_key_jwt', 'tls_client_auth', 'self_signed_tls_client_auth']", provider.TokenEndpointAuthMethod))
+	}
+
+	return msgs
+}
+
+// usesClientSecret reports whether the provider's client authentication
+// needs the client secret
+func usesClientSecret(provider options.Provider) bool {
+	switch provider.TokenEndpointAuthMethod {
+	case options.PrivateKeyJWTAuthMethod, options.TLSClientAuthMethod, options.SelfSignedTLSClientAuthMethod:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,36 @@
+package validation
+
+import (
+	"fmt"
+
+	"oidc/pkg/apis/options"
+)
+
+func validateSessions(o *options.Options) []string {
+	msgs := []string{}
+
+	switch o.Session.Type {
+	case options.CookieSessionStoreType:
+		if o.Admin.Enabled() {
+			msgs = append(msgs, "the session administration API (admin_groups, admin_bearer_token) requires a server side session store")
+		}
+		if o.Session.BackgroundRefresh {
+			msgs = append(msgs, "session_background_refresh requires a server side session store")
+		}
+	case options.MemorySessionStoreType:
+	default:
+		msgs = append(msgs, fmt.Sprintf("session_store_type (%q) must be one of ['cookie', 'memory']", o.Session.Type))
+	}
+
+	if o.Session.RefreshBeforeExpiry < 0 {
+		msgs = append(msgs, fmt.Sprintf("session_refresh_before_expiry (%q) must not be negative", o.Session.RefreshBeforeExpiry.String()))
+	}
+	if o.Session.IdleTimeout < 0 {
+		msgs = append(msgs, fmt.Sprintf("session_idle_timeout (%q) must not be negative", o.Session.IdleTimeout.String()))
+	}
+	if o.Session.MaxLifetime < 0 {
+		msgs = append(msgs, fmt.Sprintf("session_max_lifetime (%q) must not be negative", o.Session.MaxLifetime.String()))
+	}
+
+	return msgs
+}
@@ -0,0 +1,29 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+
+	"oidc/pkg/apis/options"
+)
+
+func validateStepUpRoutes(o *options.Options) []string {
+	msgs := []string{}
+
+	for i, route := range o.StepUpRoutes {
+		if route.Path == "" {
+			msgs = append(msgs, fmt.Sprintf("step_up_routes[%d] is missing a path", i))
+		} else if _, err := regexp.Compile(route.Path); err != nil {
+			msgs = append(msgs, fmt.Sprintf("step_up_routes[%d] path (%q) is not a valid regular expression: %v", i, route.Path, err))
+		}
+
+		if route.MaxAge < 0 {
+			msgs = append(msgs, fmt.Sprintf("step_up_routes[%d] max_age (%q) must not be negative", i, route.MaxAge.String()))
+		}
+		if len(route.ACRValues) == 0 && len(route.AMR) == 0 && route.MaxAge == 0 {
+			msgs = append(msgs, fmt.Sprintf("step_up_routes[%d] (%q) must require at least one of acr_values, amr or max_age", i, route.Path))
+		}
+	}
+
+	return msgs
+}
@@ -0,0 +1,26 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+
+	"oidc/pkg/apis/options"
+)
+
+func validateTokenExchangeRoutes(o *options.Options) []string {
+	msgs := []string{}
+
+	for i, route := range o.TokenExchangeRoutes {
+		if route.Path == "" {
+			msgs = append(msgs, fmt.Sprintf("token_exchange_routes[%d] is missing a path", i))
+		} else if _, err := regexp.Compile(route.Path); err != nil {
+			msgs = append(msgs, fmt.Sprintf("token_exchange_routes[%d] path (%q) is not a valid regular expression: %v", i, route.Path, err))
+		}
+
+		if route.Audience == "" && route.Resource == "" {
+			msgs = append(msgs, fmt.Sprintf("token_exchange_routes[%d] (%q) must set an audience or resource", i, route.Path))
+		}
+	}
+
+	return msgs
+}
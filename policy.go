@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"oidc/pkg/apis/options"
+	sessionsapi "oidc/pkg/apis/sessions"
+	"oidc/pkg/ip"
+	"oidc/pkg/policy"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
+)
+
+// policyRoute is a compiled options.PolicyRoute
+type policyRoute struct {
+	name    string
+	path    *regexp.Regexp
+	methods []string
+	policy  *policy.Policy
+}
+
+func buildPolicyRoutes(opts *options.Options) ([]policyRoute, error) {
+	routes := make([]policyRoute, 0, len(opts.PolicyRoutes))
+	for _, route := range opts.PolicyRoutes {
+		path, err := regexp.Compile(route.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling policy route path %q: %v", route.Path, err)
+		}
+		compiled, err := policy.New(route.Policy)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling policy %q: %v", route.Policy, err)
+		}
+		name := route.Name
+		if name == "" {
+			name = route.Path
+		}
+		routes = append(routes, policyRoute{
+			name:    name,
+			path:    path,
+			methods: route.Methods,
+			policy:  compiled,
+		})
+	}
+	return routes, nil
+}
+
+// matchPolicyRoute returns the first policy route matching the method and
+// path
+func matchPolicyRoute(routes []policyRoute, method, path string) *policyRoute {
+	for i := range routes {
+		if !routes[i].path.MatchString(path) {
+			continue
+		}
+		if len(routes[i].methods) > 0 && !containsFold(routes[i].methods, method) {
+			continue
+		}
+		return &routes[i]
+	}
+	return nil
+}
+
+// checkPolicy evaluates the policy of the route matching the request. It
+// returns the route and the reason the session was denied, or an empty
+// reason if the request is allowed.
+func (p *OAuthProxy) checkPolicy(req *http.Request, session *sessionsapi.SessionState) (*policyRoute, string) {
+	route := matchPolicyRoute(p.policyRoutes, req.Method, requestPath(req))
+	if route == nil {
+		return nil, ""
+	}
+	allowed, reason := route.policy.Evaluate(p.policyInput(req, session))
+	if allowed {
+		return route, ""
+	}
+	return route, reason
+}
+
+// policyInput describes the session and request to the policy. The claims
+// are taken from the session's ID token, which was verified when the session
+// was created.
+func (p *OAuthProxy) policyInput(req *http.Request, session *sessionsapi.SessionState) policy.Input {
+	in := policy.Input{
+		User:     session.User,
+		Email:    session.Email,
+		Groups:   session.Groups,
+		ClientIP: ip.GetClientString(p.realClientIPParser, req, false),
+		Method:   req.Method,
+		Host:     requestutil.GetRequestHost(req),
+		Path:     requestPath(req),
+		Claims:   sessionClaims(session),
+		Headers:  make(map[string]string, len(req.Header)),
+	}
+	for name := range req.Header {
+		in.Headers[name] = req.Header.Get(name)
+	}
+	return in
+}
+
+// requestPath returns the path of the request being authorized. Behind a
+// forward auth front end it is taken from `X-Forwarded-Uri`, like the DPoP
+// proof's target.
+func requestPath(req *http.Request) string {
+	uri, err := url.ParseRequestURI(requestutil.GetRequestURI(req))
+	if err != nil {
+		return req.URL.Path
+	}
+	return uri.Path
+}
+
+// sessionClaims returns the claims of the session's ID token, or nil if it
+// has none
+func sessionClaims(session *sessionsapi.SessionState) map[string]interface{} {
+	if session.IDToken == "" {
+		return nil
+	}
+	var claims map[string]interface{}
+	token, err := jwt.ParseSigned(session.IDToken)
+	if err == nil {
+		err = token.UnsafeClaimsWithoutVerification(&claims)
+	}
+	if err != nil {
+		logger.Errorf("Unable to extract claims from the session's ID token: %v", err)
+		return nil
+	}
+	return claims
+}
+
+// containsFold reports whether values contains value, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"oidc/pkg/policy"
+)
+
+// policyTestFile lists the cases checked by the policy-test command
+type policyTestFile struct {
+	Tests []policyTestCase `json:"tests"`
+}
+
+// policyTestCase is a request and session, and whether the configured
+// policy routes should allow it
+type policyTestCase struct {
+	Name     string            `json:"name"`
+	Method   string            `json:"method"`
+	Host     string            `json:"host"`
+	Path     string            `json:"path"`
+	Headers  map[string]string `json:"headers"`
+	ClientIP string            `json:"client_ip"`
+	Session  struct {
+		User   string                 `json:"user"`
+		Email  string                 `json:"email"`
+		Groups []string               `json:"groups"`
+		Claims map[string]interface{} `json:"claims"`
+	} `json:"session"`
+	Allow bool `json:"allow"`
+}
+
+// runPolicyTest checks the policy routes of the configuration against a file
+// of test cases, printing the outcome of each. It returns the exit code,
+// which is 1 if any case failed.
+//
+//	policy-test [-config data.json] tests.json
+func runPolicyTest(args []string) int {
+	flags := flag.NewFlagSet("policy-test", flag.ContinueOnError)
+	config := flags.String("config", configFile, "configuration file with the policy routes")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: policy-test [-config data.json] tests.json")
+		return 2
+	}
+
+	opts, err := loadConfig(*config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 2
+	}
+	routes, err := buildPolicyRoutes(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 2
+	}
+
+	content, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: error reading policy tests: %v\n", err)
+		return 2
+	}
+	var tests policyTestFile
+	if err := json.Unmarshal(content, &tests); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: error parsing policy tests: %v\n", err)
+		return 2
+	}
+
+	if failed := checkPolicies(os.Stdout, routes, tests.Tests); failed > 0 {
+		fmt.Printf("%d of %d policy tests failed\n", failed, len(tests.Tests))
+		return 1
+	}
+	fmt.Printf("all %d policy tests passed\n", len(tests.Tests))
+	return 0
+}
+
+// checkPolicies evaluates each case against the routes, writes its outcome
+// and returns how many cases failed
+func checkPolicies(w io.Writer, routes []policyRoute, tests []policyTestCase) int {
+	failed := 0
+	for i, tc := range tests {
+		name := tc.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		method := tc.Method
+		if method == "" {
+			method = "GET"
+		}
+
+		allowed, outcome := true, "no policy route matched"
+		if route := matchPolicyRoute(routes, method, tc.Path); route != nil {
+			var reason string
+			allowed, reason = route.policy.Evaluate(policy.Input{
+				User:     tc.Session.User,
+				Email:    tc.Session.Email,
+				Groups:   tc.Session.Groups,
+				Claims:   tc.Session.Claims,
+				ClientIP: tc.ClientIP,
+				Method:   method,
+				Host:     tc.Host,
+				Path:     tc.Path,
+				Headers:  tc.Headers,
+			})
+			if allowed {
+				outcome = fmt.Sprintf("allowed by policy route %q", route.name)
+			} else {
+				outcome = fmt.Sprintf("denied by policy route %q: %s", route.name, reason)
+			}
+		}
+
+		if allowed == tc.Allow {
+			fmt.Fprintf(w, "PASS %s: %s %s %s\n", name, method, tc.Path, outcome)
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "FAIL %s: %s %s expected allow=%t, %s\n", name, method, tc.Path, tc.Allow, outcome)
+	}
+	return failed
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"oidc/pkg/apis/options"
+	sessionsapi "oidc/pkg/apis/sessions"
+	"oidc/pkg/cookies"
+	"oidc/pkg/encryption"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// preservedPostCookieSuffix is appended to the cookie name for the cookie
+// holding a preserved POST body, or its key in the session store
+const preservedPostCookieSuffix = "_post"
+
+// preservedPost is a form POST that required a login, kept until the
+// callback so it can be replayed
+type preservedPost struct {
+	URL      string `json:"u"`
+	Body     string `json:"b"`
+	IssuedAt int64  `json:"t"`
+}
+
+// preservedPostReplayTemplate re-submits a preserved form POST to the URL it
+// was originally sent to, now that the user is authenticated
+var preservedPostReplayTemplate = template.Must(template.New("replay").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Resuming</title></head>
+<body>
+<form method="post" action="{{.Action}}">
+{{- range $name, $values := .Params}}{{range $values}}
+  <input type="hidden" name="{{$name}}" value="{{.}}">
+{{- end}}{{end}}
+  <noscript><button type="submit">Continue</button></noscript>
+</form>
+<script>document.forms[0].submit();</script>
+</body>
+</html>
+`))
+
+type preservedPostReplay struct {
+	Action string
+	Params url.Values
+}
+
+// fragmentInterstitialTemplate starts the login from the browser, adding the
+// URL fragment to the redirect as it is never sent to the server
+var fragmentInterstitialTemplate = template.Must(template.New("fragment").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Signing in</title></head>
+<body>
+<script>
+  (function () {
+    window.location.replace({{.Start}} + encodeURIComponent({{.Redirect}} + window.location.hash));
+  })();
+</script>
+<noscript><a href="{{.Fallback}}">Sign in</a></noscript>
+</body>
+</html>
+`))
+
+type fragmentInterstitial struct {
+	Start    string
+	Redirect string
+	Fallback string
+}
+
+// preservePostBody stashes the body of a form POST that requires a login,
+// so it can be replayed once the user is back from the provider. Only url
+// encoded bodies of same-origin POSTs up to the configured limit are kept,
+// anything else is lost as it is without this option.
+func (p *OAuthProxy) preservePostBody(rw http.ResponseWriter, req *http.Request) {
+	if !p.preserveRequest.PostBody || req.Method != http.MethodPost {
+		return
+	}
+	// The replay comes from the proxy's own origin, so replaying a cross-site
+	// POST would get it past the upstream's SameSite and Origin checks
+	if !p.isSameOriginRequest(req) {
+		logger.Printf("Not preserving POST body across login: the request is not same-origin")
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		logger.Printf("Not preserving POST body across login: unsupported content type %q", req.Header.Get("Content-Type"))
+		return
+	}
+	if req.ContentLength < 0 || req.ContentLength > p.preserveRequest.PostBodyLimit {
+		logger.Printf("Not preserving POST body across login: %d bytes exceeds the limit of %d", req.ContentLength, p.preserveRequest.PostBodyLimit)
+		return
+	}
+
+	// Getting the redirect parses the form, which reads the body
+	redirect, err := p.appDirector.GetRedirect(req)
+	if err != nil {
+		logger.Errorf("Error obtaining redirect to preserve POST body: %v", err)
+		return
+	}
+	packed, err := json.Marshal(preservedPost{
+		URL:      redirect,
+		Body:     req.PostForm.Encode(),
+		IssuedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		logger.Errorf("Error marshalling preserved POST body: %v", err)
+		return
+	}
+	encrypted, err := p.stateCipher.Encrypt(packed)
+	if err != nil {
+		logger.Errorf("Error encrypting preserved POST body: %v", err)
+		return
+	}
+
+	value := base64.RawURLEncoding.EncodeToString(encrypted)
+	if store, ok := p.dataStore(); ok {
+		key, err := encryption.Nonce(32)
+		if err != nil {
+			logger.Errorf("Error creating preserved POST body key: %v", err)
+			return
+		}
+		value = base64.RawURLEncoding.EncodeToString(key)
+		if err := store.SaveData(req.Context(), value, encrypted, p.stateLifetime()); err != nil {
+			logger.Errorf("Error storing preserved POST body: %v", err)
+			return
+		}
+	}
+
+	http.SetCookie(rw, cookies.MakeCookieFromOptions(
+		req,
+		p.CookieOptions.Name+preservedPostCookieSuffix,
+		value,
+		p.CookieOptions,
+		p.stateLifetime(),
+		time.Now(),
+	))
+}
+
+// isSameOriginRequest reports whether the browser says the request was sent
+// by a page of the origin it was sent to, with `Sec-Fetch-Site` or else the
+// `Origin` header. Requests with neither are not trusted.
+func (p *OAuthProxy) isSameOriginRequest(req *http.Request) bool {
+	if site := req.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin"
+	}
+	origin := req.Header.Get("Origin")
+	return origin != "" && strings.EqualFold(origin, p.requestOrigin(req))
+}
+
+// replayPostBody answers the callback with a page re-submitting the POST
+// preserved when the login started, if it was sent to the application
+// redirect. It returns whether the page was written.
+func (p *OAuthProxy) replayPostBody(rw http.ResponseWriter, req *http.Request, appRedirect string) bool {
+	if !p.preserveRequest.PostBody {
+		return false
+	}
+	preserved, err := p.loadPreservedPost(rw, req)
+	if err != nil {
+		if !errors.Is(err, http.ErrNoCookie) {
+			logger.Errorf("Error loading preserved POST body: %v", err)
+		}
+		return false
+	}
+	if preserved.URL != appRedirect {
+		return false
+	}
+	params, err := url.ParseQuery(preserved.Body)
+	if err != nil {
+		logger.Errorf("Error parsing preserved POST body: %v", err)
+		return false
+	}
+
+	prepareNoCache(rw)
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	err = preservedPostReplayTemplate.Execute(rw, preservedPostReplay{
+		Action: preserved.URL,
+		Params: params,
+	})
+	if err != nil {
+		logger.Errorf("Error rendering preserved POST replay: %v", err)
+	}
+	return true
+}
+
+// loadPreservedPost loads and removes the preserved POST body. It can only
+// be replayed once.
+func (p *OAuthProxy) loadPreservedPost(rw http.ResponseWriter, req *http.Request) (*preservedPost, error) {
+	name := p.CookieOptions.Name + preservedPostCookieSuffix
+	c, err := req.Cookie(name)
+	if err != nil {
+		return nil, err
+	}
+	http.SetCookie(rw, cookies.MakeCookieFromOptions(req, name, "", p.CookieOptions, time.Hour*-1, time.Now()))
+
+	value, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding cookie: %v", err)
+	}
+	encrypted := value
+	if store, ok := p.dataStore(); ok {
+		encrypted, err = store.LoadData(req.Context(), c.Value)
+		if err != nil {
+			return nil, fmt.Errorf("error loading from session store: %v", err)
+		}
+		if err := store.ClearData(req.Context(), c.Value); err != nil {
+			logger.Errorf("Error clearing preserved POST body from session store: %v", err)
+		}
+	}
+
+	packed, err := p.stateCipher.Decrypt(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting: %v", err)
+	}
+	var preserved preservedPost
+	if err := json.Unmarshal(packed, &preserved); err != nil {
+		return nil, fmt.Errorf("error unmarshalling: %v", err)
+	}
+	if !p.withinStateLifetime(preserved.IssuedAt) {
+		return nil, errors.New("expired")
+	}
+	return &preserved, nil
+}
+
+// dataStore returns the session store when preserved POST bodies are kept
+// in it rather than in the cookie
+func (p *OAuthProxy) dataStore() (sessionsapi.DataStore, bool) {
+	if p.preserveRequest.PostBodyStore != options.PreserveStoreSession {
+		return nil, false
+	}
+	store, ok := p.sessionStore.(sessionsapi.DataStore)
+	return store, ok
+}
+
+// startLoginWithFragment answers a navigation that requires a login with a
+// page adding the URL fragment to the redirect before sending the browser to
+// the start endpoint with params. It returns false when the fragment can't
+// be part of the request, leaving the login to the caller.
+func (p *OAuthProxy) startLoginWithFragment(rw http.ResponseWriter, req *http.Request, params url.Values) bool {
+	if !p.preserveRequest.Fragment || req.Method != http.MethodGet {
+		return false
+	}
+	redirect, err := p.appDirector.GetRedirect(req)
+	if err != nil {
+		logger.Errorf("Error obtaining application redirect: %v", err)
+		return false
+	}
+
+	start := p.ProxyPrefix + oauthStartPath + "?"
+	if len(params) > 0 {
+		start += params.Encode() + "&"
+	}
+	fallback := url.Values{"rd": {redirect}}
+	for key, values := range params {
+		fallback[key] = values
+	}
+
+	prepareNoCache(rw)
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	err = fragmentInterstitialTemplate.Execute(rw, fragmentInterstitial{
+		Start:    start + "rd=",
+		Redirect: redirect,
+		Fallback: p.ProxyPrefix + oauthStartPath + "?" + fallback.Encode(),
+	})
+	if err != nil {
+		logger.Errorf("Error rendering fragment interstitial: %v", err)
+	}
+	return true
+}
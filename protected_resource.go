@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	middlewareapi "oidc/pkg/apis/middleware"
+
+	requestutil "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests/util"
+)
+
+// protectedResourceMetadataPath is the well-known path of the RFC 9728
+// protected resource metadata
+const protectedResourceMetadataPath = "/.well-known/oauth-protected-resource"
+
+// invalidTokenDescription is sent for every rejected bearer token. Why it was
+// rejected is only logged, so provider errors aren't shown to clients.
+const invalidTokenDescription = "the access token is invalid or expired"
+
+// protectedResourceMetadata is the RFC 9728 metadata document describing how
+// to obtain access tokens for the resources behind the proxy
+type protectedResourceMetadata struct {
+	Resource               string   `json:"resource"`
+	AuthorizationServers   []string `json:"authorization_servers,omitempty"`
+	BearerMethodsSupported []string `json:"bearer_methods_supported"`
+	ScopesSupported        []string `json:"scopes_supported,omitempty"`
+}
+
+// challengeQuoter escapes a value for a quoted-string auth-param
+var challengeQuoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// ProtectedResourceMetadata serves the RFC 9728 protected resource metadata,
+// so OAuth aware clients can discover the authorization server
+func (p *OAuthProxy) ProtectedResourceMetadata(rw http.ResponseWriter, req *http.Request) {
+	metadata := protectedResourceMetadata{
+		Resource:               p.protectedResourceURL(req).String(),
+		BearerMethodsSupported: []string{"header"},
+		ScopesSupported:        strings.Fields(p.provider.Data().Scope),
+	}
+	if p.issuerURL != "" {
+		metadata.AuthorizationServers = []string{p.issuerURL}
+	}
+	writeJSON(rw, http.StatusOK, metadata)
+}
+
+// protectedResourceMetadataRoute is the path the metadata is served at. For
+// a resource identifier with a path, the well-known path is inserted before
+// it.
+func (p *OAuthProxy) protectedResourceMetadataRoute() string {
+	if p.protectedResource == nil {
+		return protectedResourceMetadataPath
+	}
+	return protectedResourceMetadataPath + strings.TrimSuffix(p.protectedResource.Path, "/")
+}
+
+// protectedResourceURL returns the configured resource identifier, or the
+// origin the request was sent to
+func (p *OAuthProxy) protectedResourceURL(req *http.Request) *url.URL {
+	if p.protectedResource != nil {
+		resource := *p.protectedResource
+		return &resource
+	}
+
+	resource := &url.URL{
+		Scheme: requestutil.GetRequestProto(req),
+		Host:   requestutil.GetRequestHost(req),
+	}
+	if resource.Scheme == "" {
+		resource.Scheme = schemeHTTP
+	}
+	if p.CookieOptions.Secure {
+		resource.Scheme = schemeHTTPS
+	}
+	return resource
+}
+
+// bearerChallenge builds an RFC 6750 `WWW-Authenticate` challenge pointing
+// clients at the protected resource metadata. The error is left out when the
+// request carried no token.
+func (p *OAuthProxy) bearerChallenge(req *http.Request, errorCode, description string) string {
+	resource := p.protectedResourceURL(req)
+	metadataURL := url.URL{
+		Scheme: resource.Scheme,
+		Host:   resource.Host,
+		Path:   p.protectedResourceMetadataRoute(),
+	}
+
+	params := []string{`resource_metadata="` + challengeQuoter.Replace(metadataURL.String()) + `"`}
+	if errorCode != "" {
+		params = append(params,
+			`error="`+errorCode+`"`,
+			`error_description="`+challengeQuoter.Replace(description)+`"`,
+		)
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// writeLoginRequired answers an API or AJAX request without a valid session
+// with a 401. A rejected bearer token is reported as `invalid_token`, and a
+// 503 is returned when the provider couldn't check it. Otherwise the body
+// carries the URL to log in at.
+func (p *OAuthProxy) writeLoginRequired(rw http.ResponseWriter, req *http.Request) {
+	switch middlewareapi.GetRequestScope(req).BearerError {
+	case "":
+	case middlewareapi.BearerErrorUnavailable:
+		writeOAuthError(rw, http.StatusServiceUnavailable, "temporarily_unavailable", "the access token could not be checked, try again later")
+		return
+	default:
+		rw.Header().Set("WWW-Authenticate", p.bearerChallenge(req, "invalid_token", invalidTokenDescription))
+		writeOAuthError(rw, http.StatusUnauthorized, "invalid_token", invalidTokenDescription)
+		return
+	}
+
+	rw.Header().Set("WWW-Authenticate", p.bearerChallenge(req, "", ""))
+	writeJSON(rw, http.StatusUnauthorized, map[string]string{
+		"error":             "login_required",
+		"error_description": "no valid session, the user needs to log in",
+		"login_url":         p.loginURL(req),
+	})
+}
+
+// writeAccessDenied answers an API or AJAX request whose session failed the
+// authorization checks with a 403. Bearer tokens are reported as having
+// `insufficient_scope`.
+func (p *OAuthProxy) writeAccessDenied(rw http.ResponseWriter, req *http.Request) {
+	const description = "the session is not authorized to access this resource"
+	if middlewareapi.GetRequestScope(req).BearerSession {
+		rw.Header().Set("WWW-Authenticate", p.bearerChallenge(req, "insufficient_scope", description))
+		writeOAuthError(rw, http.StatusForbidden, "insufficient_scope", description)
+		return
+	}
+	writeOAuthError(rw, http.StatusForbidden, "access_denied", description)
+}
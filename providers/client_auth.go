@@ -0,0 +1,370 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"oidc/pkg/apis/options"
+	"oidc/pkg/encryption"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"golang.org/x/oauth2"
+)
+
+const (
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	// clientAssertionLifetime is how long a signed client assertion is
+	// accepted by the provider
+	clientAssertionLifetime = time.Minute
+)
+
+// clientAuth authenticates the client at the provider's token endpoint and
+// the other endpoints sharing its client authentication
+type clientAuth struct {
+	method string
+
+	// client is used for requests to the provider when the client
+	// authentication needs a client certificate or a signed assertion, or
+	// token requests need DPoP proofs
+	client *http.Client
+}
+
+func newClientAuth(providerConfig options.Provider) (clientAuth, error) {
+	a := clientAuth{method: providerConfig.TokenEndpointAuthMethod}
+
+	var transport http.RoundTripper
+	var signer jose.Signer
+	switch a.method {
+	case options.PrivateKeyJWTAuthMethod:
+		var err error
+		signer, err = newClientSigner(providerConfig, "JWT")
+		if err != nil {
+			return a, err
+		}
+	case options.TLSClientAuthMethod, options.SelfSignedTLSClientAuthMethod:
+		cert, err := tls.LoadX509KeyPair(providerConfig.TLSClientCertFile, providerConfig.TLSClientKeyFile)
+		if err != nil {
+			return a, fmt.Errorf("could not load TLS client certificate: %v", err)
+		}
+		transport = clientCertTransport(cert)
+	default:
+		return a, nil
+	}
+
+	if transport == nil {
+		transport = http.DefaultClient.Transport
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if signer != nil {
+		transport = &clientAssertionTransport{signer: signer, base: transport}
+	}
+	a.client = &http.Client{Transport: transport}
+	return a, nil
+}
+
+// clientCertTransport builds a transport presenting the client certificate.
+// It keeps the TLS settings of the default client, such as the provider CA
+// files.
+func clientCertTransport(cert tls.Certificate) http.RoundTripper {
+	base, ok := http.DefaultClient.Transport.(*http.Transport)
+	if !ok {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	transport := base.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	return transport
+}
+
+// newClientSigner loads the client's private key from PEM or a JWK and
+// creates a signer for JWTs of the type, such as client assertions and
+// request objects
+func newClientSigner(providerConfig options.Provider, typ jose.ContentType) (jose.Signer, error) {
+	keyData := []byte(providerConfig.JWTKey)
+	if len(keyData) == 0 {
+		var err error
+		keyData, err = os.ReadFile(providerConfig.JWTKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read JWT key file: %v", err)
+		}
+	}
+
+	key, keyID, alg, err := parseSigningKey(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT key: %v", err)
+	}
+	if providerConfig.JWTKeyID != "" {
+		keyID = providerConfig.JWTKeyID
+	}
+
+	signerOpts := (&jose.SignerOptions{}).WithType(typ)
+	if keyID != "" {
+		signerOpts = signerOpts.WithHeader("kid", keyID)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, signerOpts)
+	if err != nil {
+		return nil, fmt.Errorf("could not create JWT signer: %v", err)
+	}
+	return signer, nil
+}
+
+// parseSigningKey parses an RSA or EC private key in PEM or JWK
+// format and picks the signing algorithm for it
+func parseSigningKey(data []byte) (interface{}, string, jose.SignatureAlgorithm, error) {
+	var key interface{}
+	var keyID string
+	var alg jose.SignatureAlgorithm
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var jwk jose.JSONWebKey
+		if err := jwk.UnmarshalJSON(trimmed); err != nil {
+			return nil, "", "", fmt.Errorf("could not parse key: %v", err)
+		}
+		if jwk.IsPublic() {
+			return nil, "", "", errors.New("key must be a private key")
+		}
+		key = jwk.Key
+		keyID = jwk.KeyID
+		alg = jose.SignatureAlgorithm(jwk.Algorithm)
+	} else {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, "", "", errors.New("could not parse key: no PEM data found")
+		}
+		var err error
+		key, err = parsePEMPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("could not parse key: %v", err)
+		}
+	}
+
+	if alg != "" {
+		return key, keyID, alg, nil
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		alg = jose.RS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			alg = jose.ES256
+		case 384:
+			alg = jose.ES384
+		case 521:
+			alg = jose.ES512
+		default:
+			return nil, "", "", fmt.Errorf("unsupported key curve %s", k.Curve.Params().Name)
+		}
+	default:
+		return nil, "", "", fmt.Errorf("unsupported key type %T: must be RSA or EC", key)
+	}
+	return key, keyID, alg, nil
+}
+
+func parsePEMPrivateKey(der []byte) (interface{}, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key format")
+}
+
+// signClientAssertion signs an RFC 7523 client assertion for the endpoint
+func signClientAssertion(signer jose.Signer, clientID, endpoint string) (string, error) {
+	jti, err := encryption.Nonce(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwt.Claims{
+		Issuer:   clientID,
+		Subject:  clientID,
+		Audience: jwt.Audience{endpoint},
+		ID:       base64.RawURLEncoding.EncodeToString(jti),
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+	}
+	return jwt.Signed(signer).Claims(claims).CompactSerialize()
+}
+
+// clientAssertionTransport adds a client assertion to form POSTs that don't
+// carry one, so requests made by the oauth2 package authenticate with
+// private_key_jwt
+type clientAssertionTransport struct {
+	signer jose.Signer
+	base   http.RoundTripper
+}
+
+func (t *clientAssertionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.Body == nil ||
+		!strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return t.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	params, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	if params.Get("client_assertion") == "" {
+		endpoint := *req.URL
+		endpoint.RawQuery = ""
+		endpoint.Fragment = ""
+		assertion, err := signClientAssertion(t.signer, params.Get("client_id"), endpoint.String())
+		if err != nil {
+			return nil, fmt.Errorf("could not sign client assertion: %v", err)
+		}
+		params.Set("client_assertion_type", clientAssertionType)
+		params.Set("client_assertion", assertion)
+		body = []byte(params.Encode())
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return t.base.RoundTrip(req)
+}
+
+// usesClientSecret reports whether the client authenticates with its secret
+func (a *clientAuth) usesClientSecret() bool {
+	switch a.method {
+	case options.PrivateKeyJWTAuthMethod, options.TLSClientAuthMethod, options.SelfSignedTLSClientAuthMethod:
+		return false
+	default:
+		return true
+	}
+}
+
+func (a *clientAuth) authStyle() oauth2.AuthStyle {
+	switch a.method {
+	case "":
+		return oauth2.AuthStyleAutoDetect
+	case options.ClientSecretBasicAuthMethod:
+		return oauth2.AuthStyleInHeader
+	default:
+		// private_key_jwt and the mTLS methods identify the client with the
+		// client_id parameter
+		return oauth2.AuthStyleInParams
+	}
+}
+
+// clientContext makes the oauth2 package use the client authentication's
+// HTTP client
+func (p *ProviderData) clientContext(ctx context.Context) context.Context {
+	if p.clientAuth.client == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, p.clientAuth.client)
+}
+
+// oauth2Config builds the oauth2.Config for requests to the token endpoint,
+// authenticating the client with the configured method
+func (p *ProviderData) oauth2Config() (*oauth2.Config, error) {
+	c := &oauth2.Config{
+		ClientID: p.ClientID,
+		Endpoint: oauth2.Endpoint{
+			TokenURL:  p.RedeemURL.String(),
+			AuthStyle: p.clientAuth.authStyle(),
+		},
+	}
+	if p.clientAuth.usesClientSecret() {
+		clientSecret, err := p.GetClientSecret()
+		if err != nil {
+			return nil, err
+		}
+		c.ClientSecret = clientSecret
+	}
+	return c, nil
+}
+
+// postForm POSTs an authenticated form to one of the provider's endpoints
+// and returns the status code and body of the response. defaultMethod is the
+// client authentication the endpoint used before a method was configured.
+func (p *ProviderData) postForm(ctx context.Context, endpoint string, params url.Values, defaultMethod string) (int, []byte, error) {
+	params = cloneValues(params)
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+	header.Set("Accept", "application/json")
+
+	method := p.clientAuth.method
+	if method == "" {
+		method = defaultMethod
+	}
+	switch method {
+	case options.ClientSecretBasicAuthMethod, options.ClientSecretPostAuthMethod:
+		clientSecret, err := p.GetClientSecret()
+		if err != nil {
+			return 0, nil, err
+		}
+		if method == options.ClientSecretBasicAuthMethod {
+			header.Set("Authorization", basicAuthHeader(p.ClientID, clientSecret))
+		} else {
+			params.Set("client_id", p.ClientID)
+			params.Set("client_secret", clientSecret)
+		}
+	default:
+		// The client assertion or certificate is added by the client
+		params.Set("client_id", p.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header = header
+
+	client := p.clientAuth.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, nil, fmt.Errorf("error reading response body: %v", err)
+	}
+	return resp.StatusCode, body, nil
+}
+
+func cloneValues(v url.Values) url.Values {
+	clone := make(url.Values, len(v))
+	for key, values := range v {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
@@ -0,0 +1,66 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+
+	"oidc/pkg/apis/sessions"
+
+	"golang.org/x/oauth2"
+)
+
+// StartDeviceAuthorization requests a device and user code from the
+// provider's RFC 8628 device authorization endpoint
+func (p *ProviderData) StartDeviceAuthorization(ctx context.Context) (*oauth2.DeviceAuthResponse, error) {
+	if p.DeviceAuthorizationURL == nil || p.DeviceAuthorizationURL.String() == "" {
+		return nil, ErrNotImplemented
+	}
+
+	c, err := p.deviceConfig()
+	if err != nil {
+		return nil, err
+	}
+	da, err := c.DeviceAuth(p.clientContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("device authorization request failed: %v", err)
+	}
+	return da, nil
+}
+
+// RedeemDeviceCode polls the token endpoint until the user approved or denied
+// the device authorization, or the device code expired
+func (p *ProviderData) RedeemDeviceCode(_ context.Context, _ *oauth2.DeviceAuthResponse) (*sessions.SessionState, error) {
+	return nil, ErrNotImplemented
+}
+
+// RedeemDeviceCode polls the token endpoint until the user approved or denied
+// the device authorization, or the device code expired, and creates a session
+// from the resulting tokens
+func (p *OIDCProvider) RedeemDeviceCode(ctx context.Context, da *oauth2.DeviceAuthResponse) (*sessions.SessionState, error) {
+	c, err := p.deviceConfig()
+	if err != nil {
+		return nil, err
+	}
+	token, err := c.DeviceAccessToken(p.clientContext(ctx), da)
+	if err != nil {
+		return nil, err
+	}
+	s, err := p.createSession(ctx, token, false)
+	if err != nil {
+		return nil, err
+	}
+	s.DeviceFlow = true
+	return s, nil
+}
+
+func (p *ProviderData) deviceConfig() (*oauth2.Config, error) {
+	c, err := p.oauth2Config()
+	if err != nil {
+		return nil, err
+	}
+	c.Endpoint.DeviceAuthURL = p.DeviceAuthorizationURL.String()
+	if p.Scope != "" {
+		c.Scopes = []string{p.Scope}
+	}
+	return c, nil
+}
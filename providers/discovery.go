@@ -0,0 +1,83 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"oidc/pkg/apis/options"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/requests"
+)
+
+// discoveryMetadataTimeout bounds the fetch of the discovery document for
+// the metadata the verifier doesn't expose
+const discoveryMetadataTimeout = 10 * time.Second
+
+// discoveryMetadata holds the OIDC discovery document fields that are not
+// exposed by the verifier's DiscoveryProvider.
+type discoveryMetadata struct {
+	RevocationEndpoint                string            `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint       string            `json:"device_authorization_endpoint"`
+	PushedAuthorizationEndpoint       string            `json:"pushed_authorization_request_endpoint"`
+	RequirePushedAuthorization        bool              `json:"require_pushed_authorization_requests"`
+	RequireSignedRequestObject        bool              `json:"require_signed_request_object"`
+	TokenEndpointAuthMethodsSupported []string          `json:"token_endpoint_auth_methods_supported"`
+	MTLSEndpointAliases               map[string]string `json:"mtls_endpoint_aliases"`
+}
+
+// fetchDiscoveryMetadata loads the issuer's OIDC discovery document
+func fetchDiscoveryMetadata(ctx context.Context, issuerURL string) (*discoveryMetadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, discoveryMetadataTimeout)
+	defer cancel()
+
+	var m discoveryMetadata
+	requestURL := strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration"
+	if err := requests.New(requestURL).WithContext(ctx).Do().UnmarshalInto(&m); err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC configuration: %v", err)
+	}
+	return &m, nil
+}
+
+// discoveryMetadataRequiredBy names the configured option that relies on the
+// discovery metadata, or returns an empty string when the configured
+// endpoints are enough
+func discoveryMetadataRequiredBy(providerConfig options.Provider) string {
+	if providerConfig.TokenEndpointAuthMethod != "" {
+		// The method is checked against the ones the provider supports,
+		// and mutual TLS uses the endpoint aliases
+		return "token_endpoint_auth_method"
+	}
+	if providerConfig.PushedAuthorizationRequests {
+		return "pushed_authorization_requests"
+	}
+	if providerConfig.SignedRequestObject {
+		return "signed_request_object"
+	}
+	return ""
+}
+
+// checkTokenEndpointAuthMethod fails when the provider doesn't list the
+// configured client authentication method. Providers that don't publish
+// their methods are trusted to support it.
+func (m *discoveryMetadata) checkTokenEndpointAuthMethod(method string) error {
+	if method == "" || len(m.TokenEndpointAuthMethodsSupported) == 0 {
+		return nil
+	}
+	for _, supported := range m.TokenEndpointAuthMethodsSupported {
+		if supported == method {
+			return nil
+		}
+	}
+	return fmt.Errorf("token_endpoint_auth_method %q is not supported by the provider, it supports %q", method, m.TokenEndpointAuthMethodsSupported)
+}
+
+// mtlsEndpoint returns the RFC 8705 mutual TLS alias of the endpoint, or the
+// endpoint itself when the provider has none
+func (m *discoveryMetadata) mtlsEndpoint(name, endpoint string) string {
+	if alias := m.MTLSEndpointAliases[name]; alias != "" {
+		return alias
+	}
+	return endpoint
+}
@@ -0,0 +1,205 @@
+package providers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"oidc/pkg/encryption"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// dpopKey holds the key pair that RFC 9449 DPoP proofs are signed with, and
+// the latest server nonces by origin
+type dpopKey struct {
+	signer jose.Signer
+
+	mu     sync.Mutex
+	nonces map[string]string
+}
+
+// dpopClaims are the claims of a DPoP proof
+type dpopClaims struct {
+	jwt.Claims
+	HTM   string `json:"htm"`
+	HTU   string `json:"htu"`
+	ATH   string `json:"ath,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// newDPoPKey loads the DPoP key from keyFile. Without a key file a P-256 key
+// is generated, so tokens bound to it can't be used after a restart or by
+// other instances.
+func newDPoPKey(keyFile string) (*dpopKey, error) {
+	var key interface{}
+	var alg jose.SignatureAlgorithm
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read DPoP key file: %v", err)
+		}
+		key, _, alg, err = parseSigningKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DPoP key: %v", err)
+		}
+	} else {
+		generated, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("could not generate DPoP key: %v", err)
+		}
+		key, alg = generated, jose.ES256
+	}
+
+	signerOpts := (&jose.SignerOptions{EmbedJWK: true}).WithType("dpop+jwt")
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, signerOpts)
+	if err != nil {
+		return nil, fmt.Errorf("could not create DPoP signer: %v", err)
+	}
+	return &dpopKey{signer: signer, nonces: make(map[string]string)}, nil
+}
+
+// proof signs a DPoP proof for a request. The access token is bound to the
+// proof with `ath` when it is set.
+func (k *dpopKey) proof(method, target, accessToken string) (string, error) {
+	htu, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid DPoP target URL: %v", err)
+	}
+	htu.RawQuery = ""
+	htu.Fragment = ""
+
+	jti, err := encryption.Nonce(32)
+	if err != nil {
+		return "", err
+	}
+	claims := dpopClaims{
+		Claims: jwt.Claims{
+			ID:       base64.RawURLEncoding.EncodeToString(jti),
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+		HTM:   method,
+		HTU:   htu.String(),
+		Nonce: k.nonce(htu),
+	}
+	if accessToken != "" {
+		ath := sha256.Sum256([]byte(accessToken))
+		claims.ATH = base64.RawURLEncoding.EncodeToString(ath[:])
+	}
+	return jwt.Signed(k.signer).Claims(claims).CompactSerialize()
+}
+
+func (k *dpopKey) nonce(u *url.URL) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.nonces[u.Scheme+"://"+u.Host]
+}
+
+// updateNonce keeps the nonce a server sent with `DPoP-Nonce` for the next
+// proof sent to it
+func (k *dpopKey) updateNonce(u *url.URL, header http.Header) {
+	nonce := header.Get("DPoP-Nonce")
+	if nonce == "" {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.nonces[u.Scheme+"://"+u.Host] = nonce
+}
+
+// DPoPProof signs a DPoP proof binding the access token to a request to an
+// upstream
+func (p *ProviderData) DPoPProof(method, target, accessToken string) (string, error) {
+	if p.dpop == nil {
+		return "", ErrNotImplemented
+	}
+	return p.dpop.proof(method, target, accessToken)
+}
+
+// useDPoP makes the client send DPoP proofs to the token endpoint
+func (a *clientAuth) useDPoP(key *dpopKey, tokenURL url.URL) {
+	base := http.DefaultClient.Transport
+	if a.client != nil {
+		base = a.client.Transport
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	tokenURL.RawQuery = ""
+	tokenURL.Fragment = ""
+	a.client = &http.Client{Transport: &dpopTransport{key: key, tokenURL: tokenURL.String(), base: base}}
+}
+
+// dpopTransport adds DPoP proofs to token endpoint requests, so the tokens
+// the provider issues are bound to the DPoP key. A request rejected with
+// `use_dpop_nonce` is retried once with the server's nonce.
+type dpopTransport struct {
+	key      *dpopKey
+	tokenURL string
+	base     http.RoundTripper
+}
+
+func (t *dpopTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := *req.URL
+	target.RawQuery = ""
+	target.Fragment = ""
+	if target.String() != t.tokenURL {
+		return t.base.RoundTrip(req)
+	}
+
+	resp, err := t.send(req)
+	if err != nil {
+		return nil, err
+	}
+	t.key.updateNonce(req.URL, resp.Header)
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("DPoP-Nonce") == "" || req.GetBody == nil {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var oauthErr struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &oauthErr) != nil || oauthErr.Error != "use_dpop_nonce" {
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	}
+
+	retry := req.Clone(req.Context())
+	retry.Body, err = req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	resp, err = t.send(retry)
+	if err != nil {
+		return nil, err
+	}
+	t.key.updateNonce(req.URL, resp.Header)
+	return resp, nil
+}
+
+func (t *dpopTransport) send(req *http.Request) (*http.Response, error) {
+	proof, err := t.key.proof(req.Method, req.URL.String(), "")
+	if err != nil {
+		return nil, fmt.Errorf("could not sign DPoP proof: %v", err)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("DPoP", proof)
+	return t.base.RoundTrip(req)
+}
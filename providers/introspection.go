@@ -0,0 +1,276 @@
+package providers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"oidc/pkg/apis/options"
+	"oidc/pkg/apis/sessions"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// ErrTokenInactive is returned when token introspection reports that a
+// token is not active
+var ErrTokenInactive = errors.New("token is not active")
+
+// ErrTokenAudience is returned when an introspected token was issued to
+// another client or resource
+var ErrTokenAudience = errors.New("token was not issued for this client")
+
+// ErrIntrospectionUnavailable is returned when the introspection endpoint
+// can't be reached or fails with a server error, so the token's validity is
+// unknown
+var ErrIntrospectionUnavailable = errors.New("token introspection is unavailable")
+
+// Inactive introspection results are cached for at most this long, so a
+// stream of invalid tokens doesn't reach the provider on every request
+const introspectionInactiveCacheTTL = 10 * time.Second
+
+// introspectionConfig holds the RFC 7662 token introspection settings
+type introspectionConfig struct {
+	cacheTTL    time.Duration
+	userField   string
+	emailField  string
+	groupsField string
+
+	// audiences are accepted in the audience claims or the `client_id` of
+	// bearer tokens, like the ID token verifier accepts them
+	audiences      []string
+	audienceClaims []string
+
+	cache *introspectionCache
+}
+
+func newIntrospectionConfig(providerConfig options.Provider) introspectionConfig {
+	opts := providerConfig.Introspection
+	return introspectionConfig{
+		cacheTTL:       opts.CacheTTL,
+		userField:      opts.UserField,
+		emailField:     opts.EmailField,
+		groupsField:    opts.GroupsField,
+		audiences:      append([]string{providerConfig.ClientID}, providerConfig.OIDCConfig.ExtraAudiences...),
+		audienceClaims: providerConfig.OIDCConfig.AudienceClaims,
+		cache:          &introspectionCache{entries: make(map[[sha256.Size]byte]introspectionEntry)},
+	}
+}
+
+// audienceAllowed reports whether the token was issued to this client,
+// either through one of the audience claims or its `client_id`
+func (c introspectionConfig) audienceAllowed(r introspectionResponse) bool {
+	claims := append([]string{"client_id"}, c.audienceClaims...)
+	for _, claim := range claims {
+		for _, aud := range r.getStrings(claim) {
+			for _, allowed := range c.audiences {
+				if aud == allowed {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// introspectionResponse is an RFC 7662 introspection response. All fields
+// are kept so custom fields can be mapped into the session.
+type introspectionResponse map[string]interface{}
+
+func (r introspectionResponse) active() bool {
+	active, _ := r["active"].(bool)
+	return active
+}
+
+// expiry returns the token's `exp`, or the zero time if it has none
+func (r introspectionResponse) expiry() time.Time {
+	exp, ok := r["exp"].(float64)
+	if !ok || exp <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(exp), 0)
+}
+
+func (r introspectionResponse) getString(field string) string {
+	if field == "" {
+		return ""
+	}
+	s, _ := r[field].(string)
+	return s
+}
+
+// getStrings returns a field holding either a list of strings or a single
+// space separated string
+func (r introspectionResponse) getStrings(field string) []string {
+	if field == "" {
+		return nil
+	}
+	switch v := r[field].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// introspectionCache keeps introspection results by token hash
+type introspectionCache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]introspectionEntry
+}
+
+type introspectionEntry struct {
+	response introspectionResponse
+	expires  time.Time
+}
+
+func (c *introspectionCache) get(token string) introspectionResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := sha256.Sum256([]byte(token))
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	return entry.response
+}
+
+func (c *introspectionCache) put(token string, response introspectionResponse, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[sha256.Sum256([]byte(token))] = introspectionEntry{response: response, expires: expires}
+}
+
+func (p *ProviderData) introspectionEnabled() bool {
+	return p.IntrospectionURL != nil && p.IntrospectionURL.String() != ""
+}
+
+// introspectToken asks the provider's RFC 7662 introspection endpoint about
+// the access token. Active results are cached for the configured TTL, but
+// never past the token's expiry. Inactive results are cached briefly.
+func (p *ProviderData) introspectToken(ctx context.Context, token string) (introspectionResponse, error) {
+	if cache := p.introspection.cache; cache != nil {
+		if response := cache.get(token); response != nil {
+			return response, nil
+		}
+	}
+
+	params := url.Values{}
+	params.Add("token", token)
+	params.Add("token_type_hint", "access_token")
+
+	status, body, err := p.postForm(ctx, p.IntrospectionURL.String(), params, options.ClientSecretBasicAuthMethod)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIntrospectionUnavailable, err)
+	}
+	if status >= 500 {
+		return nil, fmt.Errorf("%w: unexpected status %d - %s", ErrIntrospectionUnavailable, status, body)
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("unexpected status %d - %s", status, body)
+	}
+	var response introspectionResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("unable to parse introspection response: %v", err)
+	}
+
+	if p.introspection.cacheTTL > 0 && p.introspection.cache != nil {
+		ttl := p.introspection.cacheTTL
+		if !response.active() && ttl > introspectionInactiveCacheTTL {
+			ttl = introspectionInactiveCacheTTL
+		}
+		expires := time.Now().Add(ttl)
+		if exp := response.expiry(); response.active() && !exp.IsZero() && exp.Before(expires) {
+			expires = exp
+		}
+		p.introspection.cache.put(token, response, expires)
+	}
+	return response, nil
+}
+
+// introspectionValid reports whether introspection considers the access
+// token active
+func (p *ProviderData) introspectionValid(ctx context.Context, token string) bool {
+	if token == "" {
+		return false
+	}
+	response, err := p.introspectToken(ctx, token)
+	if err != nil {
+		logger.Errorf("token introspection request failed: %v", err)
+		return false
+	}
+	return response.active()
+}
+
+// CreateSessionFromIntrospection converts an opaque Bearer access token into
+// a session using the provider's introspection endpoint
+func (p *ProviderData) CreateSessionFromIntrospection(ctx context.Context, token string) (*sessions.SessionState, error) {
+	if !p.introspectionEnabled() {
+		return nil, ErrNotImplemented
+	}
+
+	response, err := p.introspectToken(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("token introspection failed: %w", err)
+	}
+	if !response.active() {
+		return nil, ErrTokenInactive
+	}
+	if !p.introspection.audienceAllowed(response) {
+		return nil, ErrTokenAudience
+	}
+
+	ss := &sessions.SessionState{
+		AccessToken: token,
+		User:        response.getString(p.introspection.userField),
+		Email:       response.getString(p.introspection.emailField),
+		Groups:      response.getStrings(p.introspection.groupsField),
+		Scopes:      response.getStrings("scope"),
+	}
+	if ss.User == "" {
+		ss.User = response.getString("sub")
+	}
+	// Allow empty Email in Bearer case
+	if ss.Email == "" {
+		ss.Email = ss.User
+	}
+
+	ss.CreatedAtNow()
+	if exp := response.expiry(); !exp.IsZero() {
+		ss.SetExpiresOn(exp)
+	}
+	return ss, nil
+}
+
+// basicAuthHeader builds the RFC 6749 section 2.3.1 client authentication
+// header, which form-encodes the client ID and secret
+func basicAuthHeader(clientID, clientSecret string) string {
+	credentials := url.QueryEscape(clientID) + ":" + url.QueryEscape(clientSecret)
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+}
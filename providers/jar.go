@@ -0,0 +1,158 @@
+package providers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"oidc/pkg/apis/options"
+	"oidc/pkg/encryption"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+const (
+	// requestObjectLifetime is how long a signed request object is accepted
+	// by the provider
+	requestObjectLifetime = 5 * time.Minute
+
+	// jarmResponseMode asks for a JWT secured authorization response in the
+	// default encoding of the response type
+	jarmResponseMode = "jwt"
+)
+
+// jarmSigningAlgs are the algorithms accepted for JARM responses
+var jarmSigningAlgs = []string{
+	oidc.RS256, oidc.RS384, oidc.RS512,
+	oidc.ES256, oidc.ES384, oidc.ES512,
+	oidc.PS256, oidc.PS384, oidc.PS512,
+	oidc.EdDSA,
+}
+
+// requestObject signs RFC 9101 request objects with the client's key
+type requestObject struct {
+	signer   jose.Signer
+	audience string
+}
+
+func newRequestObject(providerConfig options.Provider) (*requestObject, error) {
+	signer, err := newClientSigner(providerConfig, "oauth-authz-req+jwt")
+	if err != nil {
+		return nil, err
+	}
+	return &requestObject{signer: signer, audience: providerConfig.OIDCConfig.IssuerURL}, nil
+}
+
+// wrap moves the authorization request parameters into a signed request
+// object
+func (r *requestObject) wrap(clientID string, params url.Values) (url.Values, error) {
+	jti, err := encryption.Nonce(32)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss": clientID,
+		"aud": r.audience,
+		"jti": base64.RawURLEncoding.EncodeToString(jti),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(requestObjectLifetime).Unix(),
+	}
+	// Repeated parameters, such as RFC 8707 `resource`, become arrays
+	for key, values := range params {
+		switch len(values) {
+		case 0:
+		case 1:
+			claims[key] = values[0]
+		default:
+			claims[key] = values
+		}
+	}
+	// Claims such as max_age are numbers in a request object
+	if maxAge, ok := claims["max_age"].(string); ok {
+		var seconds int64
+		if _, err := fmt.Sscan(maxAge, &seconds); err == nil {
+			claims["max_age"] = seconds
+		}
+	}
+
+	request, err := jwt.Signed(r.signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		return nil, err
+	}
+	// OpenID Connect still requires the response type and scope outside of
+	// the request object
+	return url.Values{
+		"client_id":     {clientID},
+		"response_type": {params.Get("response_type")},
+		"scope":         {params.Get("scope")},
+		"request":       {request},
+	}, nil
+}
+
+// secureLoginParams asks for a JARM response and signs the parameters into a
+// request object when they are enabled. The unsigned parameters are never
+// sent in place of a request object that couldn't be signed.
+func (p *ProviderData) secureLoginParams(params url.Values) (url.Values, error) {
+	if p.jarm != nil {
+		switch mode := params.Get("response_mode"); mode {
+		case "":
+			params.Set("response_mode", jarmResponseMode)
+		case "query", "fragment", "form_post":
+			params.Set("response_mode", mode+"."+jarmResponseMode)
+		}
+	}
+	if p.requestObject == nil {
+		return params, nil
+	}
+	wrapped, err := p.requestObject.wrap(p.ClientID, params)
+	if err != nil {
+		return nil, fmt.Errorf("error signing request object: %v", err)
+	}
+	return wrapped, nil
+}
+
+func newJARMVerifier(providerConfig options.Provider) *oidc.IDTokenVerifier {
+	keySet := oidc.NewRemoteKeySet(context.Background(), providerConfig.OIDCConfig.JwksURL)
+	return oidc.NewVerifier(providerConfig.OIDCConfig.IssuerURL, keySet, &oidc.Config{
+		ClientID:             providerConfig.ClientID,
+		SupportedSigningAlgs: jarmSigningAlgs,
+		SkipIssuerCheck:      providerConfig.OIDCConfig.InsecureSkipIssuerVerification,
+	})
+}
+
+// DecodeAuthorizationResponse returns the parameters of the authorization
+// response. When JARM is enabled they are taken from the `response` JWT
+// after verifying it against the provider's JWKS.
+func (p *ProviderData) DecodeAuthorizationResponse(ctx context.Context, params url.Values) (url.Values, error) {
+	if p.jarm == nil {
+		return params, nil
+	}
+
+	response := params.Get("response")
+	if response == "" {
+		return nil, errors.New("missing JWT secured authorization response")
+	}
+	token, err := p.jarm.Verify(ctx, response)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify authorization response: %v", err)
+	}
+	var claims map[string]interface{}
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("could not parse authorization response: %v", err)
+	}
+
+	decoded := url.Values{}
+	for _, key := range []string{"code", "state", "error", "error_description", "error_uri"} {
+		if value, ok := claims[key].(string); ok && value != "" {
+			decoded.Set(key, value)
+		}
+	}
+	return decoded, nil
+}
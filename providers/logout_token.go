@@ -0,0 +1,119 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// backChannelLogoutEvent is the `events` member that identifies a JWT as an
+// OIDC Back-Channel Logout token.
+const backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+const (
+	// logoutTokenMaxAge bounds how long after it was issued a logout token
+	// is accepted
+	logoutTokenMaxAge = 5 * time.Minute
+	// logoutTokenClockSkew allows for the provider's clock being ahead
+	logoutTokenClockSkew = time.Minute
+)
+
+// LogoutToken holds the claims of a verified OIDC Back-Channel Logout token
+// that identify which sessions should be logged out.
+type LogoutToken struct {
+	Subject   string
+	SessionID string
+}
+
+// VerifyLogoutToken verifies the signature, issuer and audience of an OIDC
+// Back-Channel Logout token with the provider's Verifier and then checks the
+// claims required by OpenID Connect Back-Channel Logout 1.0 section 2.6.
+// Tokens issued more than logoutTokenMaxAge ago, and tokens whose `jti` was
+// already seen, are rejected so a captured token can't be replayed.
+func (p *ProviderData) VerifyLogoutToken(ctx context.Context, rawToken string) (*LogoutToken, error) {
+	if p.Verifier == nil {
+		return nil, ErrMissingOIDCVerifier
+	}
+
+	token, err := p.Verifier.Verify(ctx, rawToken)
+	if err != nil {
+		return nil, fmt.Errorf("could not verify logout_token: %v", err)
+	}
+
+	var claims struct {
+		ID        string                            `json:"jti"`
+		SessionID string                            `json:"sid"`
+		Events    map[string]map[string]interface{} `json:"events"`
+		Nonce     *string                           `json:"nonce"`
+	}
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse logout_token claims: %v", err)
+	}
+
+	if _, ok := claims.Events[backChannelLogoutEvent]; !ok {
+		return nil, errors.New("logout_token does not contain a back-channel logout event")
+	}
+	if claims.Nonce != nil {
+		return nil, errors.New("logout_token must not contain a nonce")
+	}
+	if token.Subject == "" && claims.SessionID == "" {
+		return nil, errors.New("logout_token must contain a sub or sid claim")
+	}
+	if claims.ID == "" {
+		return nil, errors.New("logout_token must contain a jti claim")
+	}
+
+	now := time.Now()
+	if token.IssuedAt.IsZero() {
+		return nil, errors.New("logout_token must contain an iat claim")
+	}
+	if token.IssuedAt.After(now.Add(logoutTokenClockSkew)) {
+		return nil, errors.New("logout_token was issued in the future")
+	}
+	expires := token.IssuedAt.Add(logoutTokenMaxAge)
+	if now.After(expires) {
+		return nil, errors.New("logout_token is too old")
+	}
+	if !token.Expiry.IsZero() && token.Expiry.Before(expires) {
+		expires = token.Expiry
+	}
+	if !p.logoutTokenIDs.add(claims.ID, expires) {
+		return nil, errors.New("logout_token has already been used")
+	}
+
+	return &LogoutToken{
+		Subject:   token.Subject,
+		SessionID: claims.SessionID,
+	}, nil
+}
+
+// logoutTokenIDs remembers the `jti` of the logout tokens accepted until they
+// expire. It is kept in memory, so each instance of the proxy accepts a
+// token once.
+type logoutTokenIDs struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// add records the token ID until expires, and reports whether it was new
+func (t *logoutTokenIDs) add(id string, expires time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for seenID, seenExpires := range t.seen {
+		if now.After(seenExpires) {
+			delete(t.seen, seenID)
+		}
+	}
+	if _, ok := t.seen[id]; ok {
+		return false
+	}
+	if t.seen == nil {
+		t.seen = make(map[string]time.Time)
+	}
+	t.seen[id] = expires
+	return true
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
-	"time"
 
 	"oidc/pkg/apis/options"
 
@@ -20,6 +19,12 @@ type OIDCProvider struct {
 	*ProviderData
 
 	SkipNonce bool
+
+	// RefreshRetries is the number of times a refresh failing with a
+	// transient error is retried
+	RefreshRetries int
+
+	rotated *rotatedTokens
 }
 
 const oidcDefaultScope = "openid email profile"
@@ -49,43 +54,41 @@ func NewOIDCProvider(p *ProviderData, opts options.OIDCOptions) *OIDCProvider {
 	p.getAuthorizationHeaderFunc = makeOIDCHeader
 
 	return &OIDCProvider{
-		ProviderData: p,
-		SkipNonce:    opts.InsecureSkipNonce,
+		ProviderData:   p,
+		SkipNonce:      opts.InsecureSkipNonce,
+		RefreshRetries: opts.RefreshRetries,
+		rotated:        newRotatedTokens(opts.RefreshTokenGracePeriod),
 	}
 }
 
 var _ Provider = (*OIDCProvider)(nil)
 
 // GetLoginURL makes the LoginURL with optional nonce support
-func (p *OIDCProvider) GetLoginURL(redirectURI, state, nonce string, extraParams url.Values) string {
+func (p *OIDCProvider) GetLoginURL(redirectURI, state, nonce string, extraParams url.Values) (string, error) {
 	if !p.SkipNonce {
 		extraParams.Add("nonce", nonce)
 	}
-	loginURL := makeLoginURL(p.Data(), redirectURI, state, extraParams)
-	return loginURL.String()
+	loginURL, err := makeLoginURL(p.Data(), redirectURI, state, extraParams)
+	if err != nil {
+		return "", err
+	}
+	return loginURL.String(), nil
 }
 
 // Redeem exchanges the OAuth2 authentication token for an ID token
 func (p *OIDCProvider) Redeem(ctx context.Context, redirectURL, code, codeVerifier string) (*sessions.SessionState, error) {
-	clientSecret, err := p.GetClientSecret()
+	c, err := p.oauth2Config()
 	if err != nil {
 		return nil, err
 	}
+	c.RedirectURL = redirectURL
 
 	var opts []oauth2.AuthCodeOption
 	if codeVerifier != "" {
 		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
 	}
 
-	c := oauth2.Config{
-		ClientID:     p.ClientID,
-		ClientSecret: clientSecret,
-		Endpoint: oauth2.Endpoint{
-			TokenURL: p.RedeemURL.String(),
-		},
-		RedirectURL: redirectURL,
-	}
-	token, err := c.Exchange(ctx, code, opts...)
+	token, err := c.Exchange(p.clientContext(ctx), code, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("token exchange failed: %v", err)
 	}
@@ -111,7 +114,15 @@ func (p *OIDCProvider) ValidateSession(ctx context.Context, s *sessions.SessionS
 		return false
 	}
 
-	if p.SkipNonce {
+	// Opaque access tokens can only be checked by introspection
+	if p.introspectionEnabled() && !p.introspectionValid(ctx, s.AccessToken) {
+		logger.Errorf("access_token is not active")
+		return false
+	}
+
+	// Sessions from the device flow were not created by an authorization
+	// request, so they have no nonce to compare
+	if p.SkipNonce || s.DeviceFlow {
 		return true
 	}
 	err = p.checkNonce(s)
@@ -131,7 +142,7 @@ func (p *OIDCProvider) RefreshSession(ctx context.Context, s *sessions.SessionSt
 
 	err := p.redeemRefreshToken(ctx, s)
 	if err != nil {
-		return false, fmt.Errorf("unable to redeem refresh token: %v", err)
+		return false, fmt.Errorf("unable to redeem refresh token: %w", err)
 	}
 
 	return true, nil
@@ -140,25 +151,13 @@ func (p *OIDCProvider) RefreshSession(ctx context.Context, s *sessions.SessionSt
 // redeemRefreshToken uses a RefreshToken with the RedeemURL to refresh the
 // Access Token and (probably) the ID Token.
 func (p *OIDCProvider) redeemRefreshToken(ctx context.Context, s *sessions.SessionState) error {
-	clientSecret, err := p.GetClientSecret()
+	c, err := p.oauth2Config()
 	if err != nil {
 		return err
 	}
-
-	c := oauth2.Config{
-		ClientID:     p.ClientID,
-		ClientSecret: clientSecret,
-		Endpoint: oauth2.Endpoint{
-			TokenURL: p.RedeemURL.String(),
-		},
-	}
-	t := &oauth2.Token{
-		RefreshToken: s.RefreshToken,
-		Expiry:       time.Now().Add(-time.Hour),
-	}
-	token, err := c.TokenSource(ctx, t).Token()
+	token, err := p.refreshToken(ctx, *c, s)
 	if err != nil {
-		return fmt.Errorf("failed to get token: %v", err)
+		return fmt.Errorf("failed to get token: %w", err)
 	}
 
 	newSession, err := p.createSession(ctx, token, true)
@@ -175,6 +174,19 @@ func (p *OIDCProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sessi
 		s.User = newSession.User
 		s.Groups = newSession.Groups
 		s.PreferredUsername = newSession.PreferredUsername
+		if newSession.Subject != "" {
+			s.Subject = newSession.Subject
+		}
+		if newSession.SessionID != "" {
+			s.SessionID = newSession.SessionID
+		}
+		if newSession.ACR != "" {
+			s.ACR = newSession.ACR
+			s.AMR = newSession.AMR
+		}
+		if newSession.IdPAuthTime != nil {
+			s.IdPAuthTime = newSession.IdPAuthTime
+		}
 	}
 
 	s.AccessToken = newSession.AccessToken
@@ -0,0 +1,68 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"oidc/pkg/apis/options"
+)
+
+// parResponse is the RFC 9126 pushed authorization response, or an OAuth
+// error response
+type parResponse struct {
+	RequestURI       string `json:"request_uri"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (p *ProviderData) parEnabled() bool {
+	return p.pushAuthorizationRequests && p.PushedAuthorizationRequestURL != nil && p.PushedAuthorizationRequestURL.String() != ""
+}
+
+// PushAuthorizationRequest POSTs the parameters of the login URL to the
+// provider's RFC 9126 pushed authorization request endpoint and returns the
+// login URL referencing the pushed request. Without PAR the login URL is
+// returned unchanged.
+func (p *ProviderData) PushAuthorizationRequest(ctx context.Context, loginURL string) (string, error) {
+	if !p.parEnabled() {
+		return loginURL, nil
+	}
+
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		return "", fmt.Errorf("could not parse login URL: %v", err)
+	}
+	params := u.Query()
+
+	// The token endpoint's client authentication is used, which defaults to
+	// HTTP Basic as every provider has to support it
+	status, body, err := p.postForm(ctx, p.PushedAuthorizationRequestURL.String(), params, options.ClientSecretBasicAuthMethod)
+	if err != nil {
+		return "", err
+	}
+
+	var resp parResponse
+	if err := json.Unmarshal(body, &resp); err != nil && status < 300 {
+		return "", fmt.Errorf("unable to parse pushed authorization response: %v", err)
+	}
+	if status != http.StatusCreated && status != http.StatusOK {
+		if resp.Error != "" {
+			return "", fmt.Errorf("pushed authorization request failed: %s: %s", resp.Error, resp.ErrorDescription)
+		}
+		return "", fmt.Errorf("pushed authorization request failed: unexpected status %d - %s", status, body)
+	}
+	if resp.RequestURI == "" {
+		return "", errors.New("pushed authorization response did not contain a request_uri")
+	}
+
+	u.RawQuery = url.Values{
+		"client_id":   {p.ClientID},
+		"request_uri": {resp.RequestURI},
+	}.Encode()
+	return u.String(), nil
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"oidc/pkg/apis/options"
 
@@ -29,16 +30,40 @@ const (
 // ProviderData contains information required to configure all implementations
 // of OAuth2 providers
 type ProviderData struct {
+	ProviderID        string
 	ProviderName      string
 	LoginURL          *url.URL
 	RedeemURL         *url.URL
 	ProfileURL        *url.URL
 	ProtectedResource *url.URL
 	ValidateURL       *url.URL
+	RevocationURL     *url.URL
 	ClientID          string
 	ClientSecret      string
 	ClientSecretFile  string
 	Scope             string
+	// DeviceAuthorizationURL is the RFC 8628 device authorization endpoint
+	DeviceAuthorizationURL *url.URL
+	// PushedAuthorizationRequestURL is the RFC 9126 pushed authorization
+	// request endpoint
+	PushedAuthorizationRequestURL *url.URL
+	pushAuthorizationRequests     bool
+	// IntrospectionURL is the RFC 7662 token introspection endpoint
+	IntrospectionURL *url.URL
+	introspection    introspectionConfig
+	// logoutTokenIDs holds the back-channel logout tokens already accepted
+	logoutTokenIDs *logoutTokenIDs
+	// clientAuth authenticates the client at the token endpoint
+	clientAuth clientAuth
+	// dpop signs RFC 9449 DPoP proofs when tokens are sender-constrained
+	dpop *dpopKey
+	// requestObject signs the authorization request parameters into RFC
+	// 9101 request objects
+	requestObject *requestObject
+	// jarm verifies JWT secured authorization responses
+	jarm *oidc.IDTokenVerifier
+	// ResponseMode is the `response_mode` of authorization requests
+	ResponseMode string
 	// The picked CodeChallenge Method or empty if none.
 	CodeChallengeMethod string
 	// Code challenge methods supported by the Provider
@@ -262,12 +287,27 @@ func (p *ProviderData) buildSessionFromClaims(rawIDToken, accessToken string) (*
 		{p.GroupsClaim, &ss.Groups},
 		// TODO (@NickMeves) Deprecate for dynamic claim to session mapping
 		{"preferred_username", &ss.PreferredUsername},
+		{"sub", &ss.Subject},
+		{"sid", &ss.SessionID},
+		{"acr", &ss.ACR},
+		{"amr", &ss.AMR},
 	} {
 		if _, err := extractor.GetClaimInto(c.claim, c.dst); err != nil {
 			return nil, err
 		}
 	}
 
+	// Some providers send `auth_time` with a fraction, which is dropped
+	var authTime float64
+	exists, err := extractor.GetClaimInto("auth_time", &authTime)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		t := time.Unix(int64(authTime), 0)
+		ss.IdPAuthTime = &t
+	}
+
 	// `email_verified` must be present and explicitly set to `false` to be
 	// considered unverified.
 	verifyEmail := (p.EmailClaim == options.OIDCEmailClaim) && !p.AllowUnverifiedEmail
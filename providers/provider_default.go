@@ -30,15 +30,23 @@ var (
 	// but an attempt to call `Verifier.Verify` was about to be made.
 	ErrMissingOIDCVerifier = errors.New("oidc verifier is not configured")
 
+	// ErrInvalidGrant is returned when the provider rejected a refresh token,
+	// for example because it was revoked or already rotated. The session can
+	// no longer be refreshed and the user has to log in again.
+	ErrInvalidGrant = errors.New("invalid_grant")
+
 	_ Provider = (*ProviderData)(nil)
 )
 
 // GetLoginURL with typical oauth parameters
 // codeChallenge and codeChallengeMethod are the PKCE challenge and method to append to the URL params.
 // they will be empty strings if no code challenge should be presented
-func (p *ProviderData) GetLoginURL(redirectURI, state, _ string, extraParams url.Values) string {
-	loginURL := makeLoginURL(p, redirectURI, state, extraParams)
-	return loginURL.String()
+func (p *ProviderData) GetLoginURL(redirectURI, state, _ string, extraParams url.Values) (string, error) {
+	loginURL, err := makeLoginURL(p, redirectURI, state, extraParams)
+	if err != nil {
+		return "", err
+	}
+	return loginURL.String(), nil
 }
 
 // Redeem provides a default implementation of the OAuth2 token redemption process
@@ -130,8 +138,12 @@ func (p *ProviderData) Authorize(_ context.Context, s *sessions.SessionState) (b
 	return false, nil
 }
 
-// ValidateSession validates the AccessToken
+// ValidateSession validates the AccessToken, by introspection if it is
+// configured
 func (p *ProviderData) ValidateSession(ctx context.Context, s *sessions.SessionState) bool {
+	if p.introspectionEnabled() {
+		return p.introspectionValid(ctx, s.AccessToken)
+	}
 	return validateToken(ctx, p, s.AccessToken, nil)
 }
 
@@ -2,6 +2,7 @@ package providers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 
@@ -11,6 +12,7 @@ import (
 
 	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
 	internaloidc "github.com/oauth2-proxy/oauth2-proxy/v7/pkg/providers/oidc"
+	"golang.org/x/oauth2"
 	k8serrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
@@ -22,7 +24,10 @@ const (
 // Provider represents an upstream identity provider implementation
 type Provider interface {
 	Data() *ProviderData
-	GetLoginURL(redirectURI, finalRedirect, nonce string, extraParams url.Values) string
+	GetLoginURL(redirectURI, finalRedirect, nonce string, extraParams url.Values) (string, error)
+	PushAuthorizationRequest(ctx context.Context, loginURL string) (string, error)
+	DPoPProof(method, target, accessToken string) (string, error)
+	DecodeAuthorizationResponse(ctx context.Context, params url.Values) (url.Values, error)
 	Redeem(ctx context.Context, redirectURI, code, codeVerifier string) (*sessions.SessionState, error)
 	// Deprecated: Migrate to EnrichSession
 	GetEmailAddress(ctx context.Context, s *sessions.SessionState) (string, error)
@@ -31,6 +36,11 @@ type Provider interface {
 	ValidateSession(ctx context.Context, s *sessions.SessionState) bool
 	RefreshSession(ctx context.Context, s *sessions.SessionState) (bool, error)
 	CreateSessionFromToken(ctx context.Context, token string) (*sessions.SessionState, error)
+	RevokeSession(ctx context.Context, s *sessions.SessionState) error
+	StartDeviceAuthorization(ctx context.Context) (*oauth2.DeviceAuthResponse, error)
+	RedeemDeviceCode(ctx context.Context, da *oauth2.DeviceAuthResponse) (*sessions.SessionState, error)
+	ExchangeToken(ctx context.Context, subjectToken string, r TokenExchangeRequest) (*oauth2.Token, error)
+	CreateSessionFromIntrospection(ctx context.Context, token string) (*sessions.SessionState, error)
 }
 
 func NewProvider(providerConfig options.Provider) (Provider, error) {
@@ -74,10 +84,12 @@ func NewProvider(providerConfig options.Provider) (Provider, error) {
 
 func newProviderDataFromConfig(providerConfig options.Provider) (*ProviderData, error) {
 	p := &ProviderData{
+		ProviderID:       providerConfig.ID,
 		Scope:            providerConfig.Scope,
 		ClientID:         providerConfig.ClientID,
 		ClientSecret:     providerConfig.ClientSecret,
 		ClientSecretFile: providerConfig.ClientSecretFile,
+		logoutTokenIDs:   &logoutTokenIDs{},
 	}
 
 	needsVerifier, err := providerRequiresOIDCProviderVerifier(providerConfig.Type)
@@ -109,6 +121,52 @@ func newProviderDataFromConfig(providerConfig options.Provider) (*ProviderData,
 			providerConfig.ProfileURL = endpoints.UserInfoURL
 			providerConfig.OIDCConfig.JwksURL = endpoints.JWKsURL
 			p.SupportedCodeChallengeMethods = pkce.CodeChallengeAlgs
+
+			metadata, err := fetchDiscoveryMetadata(context.TODO(), providerConfig.OIDCConfig.IssuerURL)
+			if err != nil {
+				if option := discoveryMetadataRequiredBy(providerConfig); option != "" {
+					return nil, fmt.Errorf("%s needs the provider's discovery metadata: %v", option, err)
+				}
+				// The metadata isn't needed to log in, so the configured
+				// endpoints are used when it can't be fetched
+				logger.Printf("Warning: using the configured provider endpoints: %v", err)
+				metadata = &discoveryMetadata{}
+			}
+			// Endpoints that aren't required for login keep any configured value
+			if providerConfig.RevocationURL == "" {
+				providerConfig.RevocationURL = metadata.RevocationEndpoint
+			}
+			if providerConfig.DeviceAuthorizationURL == "" {
+				providerConfig.DeviceAuthorizationURL = metadata.DeviceAuthorizationEndpoint
+			}
+			if providerConfig.PushedAuthorizationRequestURL == "" {
+				providerConfig.PushedAuthorizationRequestURL = metadata.PushedAuthorizationEndpoint
+			}
+			if metadata.RequirePushedAuthorization {
+				providerConfig.PushedAuthorizationRequests = true
+			}
+			if metadata.RequireSignedRequestObject {
+				providerConfig.SignedRequestObject = true
+			}
+
+			if err := metadata.checkTokenEndpointAuthMethod(providerConfig.TokenEndpointAuthMethod); err != nil {
+				return nil, err
+			}
+			// Client certificates are presented to the mutual TLS aliases
+			// of the endpoints
+			switch providerConfig.TokenEndpointAuthMethod {
+			case options.TLSClientAuthMethod, options.SelfSignedTLSClientAuthMethod:
+				providerConfig.RedeemURL = metadata.mtlsEndpoint("token_endpoint", providerConfig.RedeemURL)
+				if providerConfig.RevocationURL == metadata.RevocationEndpoint {
+					providerConfig.RevocationURL = metadata.mtlsEndpoint("revocation_endpoint", providerConfig.RevocationURL)
+				}
+				if providerConfig.DeviceAuthorizationURL == metadata.DeviceAuthorizationEndpoint {
+					providerConfig.DeviceAuthorizationURL = metadata.mtlsEndpoint("device_authorization_endpoint", providerConfig.DeviceAuthorizationURL)
+				}
+				if providerConfig.PushedAuthorizationRequestURL == metadata.PushedAuthorizationEndpoint {
+					providerConfig.PushedAuthorizationRequestURL = metadata.mtlsEndpoint("pushed_authorization_request_endpoint", providerConfig.PushedAuthorizationRequestURL)
+				}
+			}
 		}
 	}
 
@@ -117,11 +175,15 @@ func newProviderDataFromConfig(providerConfig options.Provider) (*ProviderData,
 		dst **url.URL
 		raw string
 	}{
-		"login":    {dst: &p.LoginURL, raw: providerConfig.LoginURL},
-		"redeem":   {dst: &p.RedeemURL, raw: providerConfig.RedeemURL},
-		"profile":  {dst: &p.ProfileURL, raw: providerConfig.ProfileURL},
-		"validate": {dst: &p.ValidateURL, raw: providerConfig.ValidateURL},
-		"resource": {dst: &p.ProtectedResource, raw: providerConfig.ProtectedResource},
+		"login":         {dst: &p.LoginURL, raw: providerConfig.LoginURL},
+		"redeem":        {dst: &p.RedeemURL, raw: providerConfig.RedeemURL},
+		"profile":       {dst: &p.ProfileURL, raw: providerConfig.ProfileURL},
+		"validate":      {dst: &p.ValidateURL, raw: providerConfig.ValidateURL},
+		"revocation":    {dst: &p.RevocationURL, raw: providerConfig.RevocationURL},
+		"device":        {dst: &p.DeviceAuthorizationURL, raw: providerConfig.DeviceAuthorizationURL},
+		"par":           {dst: &p.PushedAuthorizationRequestURL, raw: providerConfig.PushedAuthorizationRequestURL},
+		"introspection": {dst: &p.IntrospectionURL, raw: providerConfig.Introspection.URL},
+		"resource":      {dst: &p.ProtectedResource, raw: providerConfig.ProtectedResource},
 	} {
 		var err error
 		*u.dst, err = url.Parse(u.raw)
@@ -162,6 +224,33 @@ func newProviderDataFromConfig(providerConfig options.Provider) (*ProviderData,
 	p.setAllowedGroups(providerConfig.AllowedGroups)
 
 	p.BackendLogoutURL = providerConfig.BackendLogoutURL
+	p.ResponseMode = providerConfig.ResponseMode
+	p.introspection = newIntrospectionConfig(providerConfig)
+	p.pushAuthorizationRequests = providerConfig.PushedAuthorizationRequests
+	if p.pushAuthorizationRequests && p.PushedAuthorizationRequestURL.String() == "" {
+		return nil, errors.New("pushed authorization requests are enabled, but the provider has no pushed authorization request endpoint")
+	}
+
+	p.clientAuth, err = newClientAuth(providerConfig)
+	if err != nil {
+		return nil, err
+	}
+	if providerConfig.DPoP {
+		p.dpop, err = newDPoPKey(providerConfig.DPoPKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		p.clientAuth.useDPoP(p.dpop, *p.RedeemURL)
+	}
+	if providerConfig.SignedRequestObject {
+		p.requestObject, err = newRequestObject(providerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("could not set up signed request objects: %v", err)
+		}
+	}
+	if providerConfig.JARM {
+		p.jarm = newJARMVerifier(providerConfig)
+	}
 
 	return p, nil
 }
@@ -0,0 +1,143 @@
+package providers
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"oidc/pkg/apis/sessions"
+
+	"golang.org/x/oauth2"
+)
+
+const refreshRetryBaseDelay = 100 * time.Millisecond
+
+// rotatedTokens remembers the tokens issued in exchange for a refresh token
+// for a short grace period. Providers that rotate refresh tokens reject the
+// old token once it has been used, so concurrent refreshes presenting the
+// same refresh token would otherwise fail with invalid_grant.
+type rotatedTokens struct {
+	gracePeriod time.Duration
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]rotatedToken
+}
+
+type rotatedToken struct {
+	token   *oauth2.Token
+	expires time.Time
+}
+
+func newRotatedTokens(gracePeriod time.Duration) *rotatedTokens {
+	return &rotatedTokens{
+		gracePeriod: gracePeriod,
+		entries:     make(map[[sha256.Size]byte]rotatedToken),
+	}
+}
+
+func (r *rotatedTokens) get(refreshToken string) *oauth2.Token {
+	if r == nil || r.gracePeriod <= 0 {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := sha256.Sum256([]byte(refreshToken))
+	entry, ok := r.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(r.entries, key)
+		return nil
+	}
+	return entry.token
+}
+
+func (r *rotatedTokens) put(refreshToken string, token *oauth2.Token) {
+	if r == nil || r.gracePeriod <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for key, entry := range r.entries {
+		if now.After(entry.expires) {
+			delete(r.entries, key)
+		}
+	}
+	r.entries[sha256.Sum256([]byte(refreshToken))] = rotatedToken{
+		token:   token,
+		expires: now.Add(r.gracePeriod),
+	}
+}
+
+// refreshToken exchanges the refresh token for new tokens. Tokens recently
+// issued for the same refresh token are reused, transient failures are
+// retried with exponential backoff and an invalid_grant response is reported
+// as ErrInvalidGrant so callers can force the user to log in again. The
+// session's lock is extended before each retry so the refresh doesn't outlive
+// it and race another refresh of the same token.
+func (p *OIDCProvider) refreshToken(ctx context.Context, c oauth2.Config, s *sessions.SessionState) (*oauth2.Token, error) {
+	refreshToken := s.RefreshToken
+	if token := p.rotated.get(refreshToken); token != nil {
+		return token, nil
+	}
+
+	t := &oauth2.Token{
+		RefreshToken: refreshToken,
+		Expiry:       time.Now().Add(-time.Hour),
+	}
+
+	delay := refreshRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		token, err := c.TokenSource(p.clientContext(ctx), t).Token()
+		if err == nil {
+			p.rotated.put(refreshToken, token)
+			return token, nil
+		}
+
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			// A concurrent refresh may have rotated the token while this
+			// request was in flight.
+			if token := p.rotated.get(refreshToken); token != nil {
+				return token, nil
+			}
+			return nil, fmt.Errorf("%w: %v", ErrInvalidGrant, err)
+		}
+
+		if attempt >= p.RefreshRetries || !isTransientRefreshError(err) {
+			return nil, err
+		}
+
+		if lockErr := s.RefreshLock(ctx, delay+sessions.RefreshLockDuration); lockErr != nil {
+			return nil, fmt.Errorf("%v, not retrying as the session lock was lost: %v", err, lockErr)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// isTransientRefreshError reports whether a failed refresh is worth retrying:
+// network failures, rate limiting and server errors.
+func isTransientRefreshError(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	if retrieveErr.Response == nil {
+		return false
+	}
+	code := retrieveErr.Response.StatusCode
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
@@ -0,0 +1,58 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"oidc/pkg/apis/options"
+	"oidc/pkg/apis/sessions"
+
+	k8serrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// RevokeSession revokes the session's refresh and access tokens at the
+// provider's RFC 7009 revocation endpoint. The refresh token is revoked
+// first as most providers will also invalidate the access tokens issued
+// with it.
+func (p *ProviderData) RevokeSession(ctx context.Context, s *sessions.SessionState) error {
+	if p.RevocationURL == nil || p.RevocationURL.String() == "" {
+		return ErrNotImplemented
+	}
+
+	var errs []error
+	for _, t := range []struct {
+		token string
+		hint  string
+	}{
+		{s.RefreshToken, "refresh_token"},
+		{s.AccessToken, "access_token"},
+	} {
+		if t.token == "" {
+			continue
+		}
+		if err := p.revokeToken(ctx, t.token, t.hint); err != nil {
+			errs = append(errs, fmt.Errorf("could not revoke %s: %v", t.hint, err))
+		}
+	}
+	return k8serrors.NewAggregate(errs)
+}
+
+// revokeToken sends a single RFC 7009 revocation request
+func (p *ProviderData) revokeToken(ctx context.Context, token, hint string) error {
+	params := url.Values{}
+	params.Add("token", token)
+	params.Add("token_type_hint", hint)
+
+	status, body, err := p.postForm(ctx, p.RevocationURL.String(), params, options.ClientSecretPostAuthMethod)
+	if err != nil {
+		return err
+	}
+
+	// The revocation endpoint responds 200 for both revoked and already
+	// invalid tokens
+	if status != 200 {
+		return fmt.Errorf("unexpected status %d - %s", status, body)
+	}
+	return nil
+}
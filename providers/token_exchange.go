@@ -0,0 +1,89 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"oidc/pkg/apis/options"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// TokenExchangeRequest describes the token requested with RFC 8693 token
+// exchange
+type TokenExchangeRequest struct {
+	Audience string
+	Resource string
+	Scope    string
+}
+
+// tokenExchangeResponse is the RFC 8693 token exchange response, or an
+// OAuth error response
+type tokenExchangeResponse struct {
+	AccessToken      string `json:"access_token"`
+	IssuedTokenType  string `json:"issued_token_type"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// ExchangeToken exchanges the subject access token at the provider's token
+// endpoint for an access token issued for the requested audience or resource
+func (p *ProviderData) ExchangeToken(ctx context.Context, subjectToken string, r TokenExchangeRequest) (*oauth2.Token, error) {
+	if subjectToken == "" {
+		return nil, errors.New("missing subject token")
+	}
+
+	params := url.Values{}
+	params.Add("grant_type", tokenExchangeGrantType)
+	params.Add("subject_token", subjectToken)
+	params.Add("subject_token_type", accessTokenType)
+	params.Add("requested_token_type", accessTokenType)
+	if r.Audience != "" {
+		params.Add("audience", r.Audience)
+	}
+	if r.Resource != "" {
+		params.Add("resource", r.Resource)
+	}
+	if r.Scope != "" {
+		params.Add("scope", r.Scope)
+	}
+
+	status, body, err := p.postForm(ctx, p.RedeemURL.String(), params, options.ClientSecretPostAuthMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp tokenExchangeResponse
+	if err := json.Unmarshal(body, &resp); err != nil && status == 200 {
+		return nil, fmt.Errorf("unable to parse token exchange response: %v", err)
+	}
+	if status != 200 {
+		if resp.Error != "" {
+			return nil, fmt.Errorf("token exchange failed: %s: %s", resp.Error, resp.ErrorDescription)
+		}
+		return nil, fmt.Errorf("token exchange failed: unexpected status %d - %s", status, body)
+	}
+	if resp.AccessToken == "" {
+		return nil, errors.New("token exchange response did not contain an access_token")
+	}
+
+	token := &oauth2.Token{
+		AccessToken: resp.AccessToken,
+		TokenType:   resp.TokenType,
+	}
+	if resp.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+
+	"oidc/pkg/middleware"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// sessionEndReasonParam carries the reason a session ended to the start
+// endpoint, which tells the user before they sign in again
+const sessionEndReasonParam = "reason"
+
+// sessionEndMessages describes the reasons a session can end. Unknown
+// reasons aren't shown.
+var sessionEndMessages = map[string]string{
+	middleware.SessionEndReasonIdleTimeout: "You were signed out because your session wasn't used for a while.",
+	middleware.SessionEndReasonMaxLifetime: "You were signed out because your session reached its maximum lifetime.",
+}
+
+// sessionEndedTemplate tells the user why their session ended, with a link
+// to sign in again
+var sessionEndedTemplate = template.Must(template.New("ended").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Session ended</title></head>
+<body>
+<p>{{.Message}}</p>
+<p><a href="{{.SignIn}}">Sign in again</a></p>
+</body>
+</html>
+`))
+
+type sessionEnded struct {
+	Message string
+	SignIn  string
+}
+
+// showSessionEnded answers a start request carrying the reason the user's
+// session ended with a page explaining it. The link to sign in again starts
+// the login with the same parameters, without the reason. It returns false
+// when there is no known reason to show.
+func (p *OAuthProxy) showSessionEnded(rw http.ResponseWriter, req *http.Request) bool {
+	query := req.URL.Query()
+	message, ok := sessionEndMessages[query.Get(sessionEndReasonParam)]
+	if !ok {
+		return false
+	}
+	query.Del(sessionEndReasonParam)
+	signIn := p.ProxyPrefix + oauthStartPath
+	if len(query) > 0 {
+		signIn += "?" + query.Encode()
+	}
+
+	prepareNoCache(rw)
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	err := sessionEndedTemplate.Execute(rw, sessionEnded{
+		Message: message,
+		SignIn:  signIn,
+	})
+	if err != nil {
+		logger.Errorf("Error rendering session ended page: %v", err)
+	}
+	return true
+}
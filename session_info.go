@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	middlewareapi "oidc/pkg/apis/middleware"
+	sessionsapi "oidc/pkg/apis/sessions"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/providers/util"
+)
+
+// userInfoResponse is the JSON document served by the userinfo endpoint
+type userInfoResponse struct {
+	User              string                 `json:"user"`
+	Email             string                 `json:"email"`
+	PreferredUsername string                 `json:"preferredUsername,omitempty"`
+	Groups            []string               `json:"groups,omitempty"`
+	Claims            map[string]interface{} `json:"claims,omitempty"`
+}
+
+// sessionInfoResponse is the JSON document served by the session endpoint
+type sessionInfoResponse struct {
+	userInfoResponse
+
+	CreatedAt       *time.Time `json:"createdAt,omitempty"`
+	AuthenticatedAt *time.Time `json:"authenticatedAt,omitempty"`
+	LastSeenAt      *time.Time `json:"lastSeenAt,omitempty"`
+	ExpiresOn       *time.Time `json:"expiresOn,omitempty"`
+	AccessToken     string     `json:"accessToken,omitempty"`
+	IDToken         string     `json:"idToken,omitempty"`
+}
+
+// UserInfo serves the authenticated user's identity as JSON
+func (p *OAuthProxy) UserInfo(rw http.ResponseWriter, req *http.Request) {
+	session, ok := p.sessionOrError(rw, req)
+	if !ok {
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, p.buildUserInfo(req.Context(), session))
+}
+
+// SessionInfo serves the authenticated user's identity along with the
+// session lifetime as JSON. Tokens are only included when configured.
+func (p *OAuthProxy) SessionInfo(rw http.ResponseWriter, req *http.Request) {
+	session, ok := p.sessionOrError(rw, req)
+	if !ok {
+		return
+	}
+
+	info := sessionInfoResponse{
+		userInfoResponse: p.buildUserInfo(req.Context(), session),
+		CreatedAt:        session.CreatedAt,
+		AuthenticatedAt:  session.AuthTime(),
+		LastSeenAt:       session.LastSeenAt,
+		ExpiresOn:        session.ExpiresOn,
+	}
+	if p.sessionInfoIncludeTokens {
+		info.AccessToken = session.AccessToken
+		info.IDToken = session.IDToken
+	}
+	writeJSON(rw, http.StatusOK, info)
+}
+
+// sessionOrError returns the request's authenticated session for the JSON
+// endpoints. If there is none an error response has been written and false
+// is returned.
+func (p *OAuthProxy) sessionOrError(rw http.ResponseWriter, req *http.Request) (*sessionsapi.SessionState, bool) {
+	session, err := p.getAuthenticatedSession(rw, req)
+	switch {
+	case err == nil && session != nil:
+		return session, true
+	case err == nil, errors.Is(err, ErrNeedsLogin):
+		writeJSON(rw, http.StatusUnauthorized, map[string]string{
+			"error":    "unauthorized",
+			"loginURL": p.loginURL(req),
+		})
+	case errors.Is(err, ErrAccessDenied):
+		writeOAuthError(rw, http.StatusForbidden, "forbidden", "the session failed authorization checks")
+	default:
+		logger.Errorf("Unexpected internal error: %v", err)
+		writeOAuthError(rw, http.StatusInternalServerError, "server_error", "internal error")
+	}
+	return nil, false
+}
+
+// buildUserInfo describes the session's identity, including any configured
+// claims from its ID token
+func (p *OAuthProxy) buildUserInfo(ctx context.Context, session *sessionsapi.SessionState) userInfoResponse {
+	info := userInfoResponse{
+		User:              session.User,
+		Email:             session.Email,
+		PreferredUsername: session.PreferredUsername,
+		Groups:            session.Groups,
+	}
+	if len(p.sessionInfoClaims) == 0 || session.IDToken == "" {
+		return info
+	}
+
+	// The ID token was verified when the session was created, so there is
+	// no need to verify it again or to consult the profile URL
+	extractor, err := util.NewClaimExtractor(ctx, session.IDToken, &url.URL{}, nil)
+	if err != nil {
+		logger.Errorf("Unable to extract claims from session: %v", err)
+		return info
+	}
+
+	info.Claims = make(map[string]interface{}, len(p.sessionInfoClaims))
+	for _, claim := range p.sessionInfoClaims {
+		value, exists, err := extractor.GetClaim(claim)
+		if err != nil {
+			logger.Errorf("Unable to extract claim %s from session: %v", claim, err)
+			continue
+		}
+		if exists {
+			info.Claims[claim] = value
+		}
+	}
+	return info
+}
+
+// loginURL returns the URL that starts the login flow and returns the user
+// to the request's application redirect afterwards. If the user's session
+// was ended while loading it, the reason is included.
+func (p *OAuthProxy) loginURL(req *http.Request) string {
+	loginURL := p.ProxyPrefix + oauthStartPath
+	params := url.Values{}
+	if redirect, err := p.appDirector.GetRedirect(req); err == nil && redirect != "" {
+		params.Set("rd", redirect)
+	}
+	if scope := middlewareapi.GetRequestScope(req); scope != nil && scope.SessionEndReason != "" {
+		params.Set(sessionEndReasonParam, scope.SessionEndReason)
+	}
+	if len(params) == 0 {
+		return loginURL
+	}
+	return loginURL + "?" + params.Encode()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	middlewareapi "oidc/pkg/apis/middleware"
+	"oidc/pkg/cookies"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// silentResultParam marks a request to the silent endpoint as the end of a
+// silent authentication flow rather than its start
+const silentResultParam = "result"
+
+// silentStartParam asks the start endpoint for a silent login, as the
+// fragment interstitial does when silent SSO is enabled
+const silentStartParam = "silent"
+
+// silentResultTemplate posts the outcome of a silent authentication flow to
+// the window that embedded the endpoint in an iframe
+var silentResultTemplate = template.Must(template.New("silent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Silent authentication</title></head>
+<body>
+<script>
+  (function () {
+    var result = {type: "oauth2-proxy:silent", authenticated: {{.Authenticated}}, error: {{.Error}}};
+    var target = window.parent !== window ? window.parent : window.opener;
+    if (target) {
+      target.postMessage(result, window.location.origin);
+    }
+  })();
+</script>
+</body>
+</html>
+`))
+
+type silentResult struct {
+	Authenticated bool
+	Error         string
+}
+
+// Silent performs a `prompt=none` authorization request meant to be loaded
+// in a hidden iframe. Once the flow completes the result is posted to the
+// parent window with postMessage.
+func (p *OAuthProxy) Silent(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if !query.Has(silentResultParam) {
+		// Return to this endpoint once the provider has answered
+		params := url.Values{"rd": {p.silentResultURL("")}}
+		startReq := req.Clone(req.Context())
+		startReq.URL.RawQuery = params.Encode()
+		p.doOAuthStart(rw, startReq, nil, url.Values{"prompt": {"none"}})
+		return
+	}
+
+	result := silentResult{
+		Authenticated: middlewareapi.GetRequestScope(req).Session != nil,
+		Error:         query.Get("error"),
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	if err := silentResultTemplate.Execute(rw, result); err != nil {
+		logger.Errorf("Error rendering silent authentication result: %v", err)
+	}
+}
+
+// silentResultURL is where a silent authentication flow started by the
+// silent endpoint ends
+func (p *OAuthProxy) silentResultURL(errorCode string) string {
+	params := url.Values{silentResultParam: {"1"}}
+	if errorCode != "" {
+		params.Set("error", errorCode)
+	}
+	return p.ProxyPrefix + silentPath + "?" + params.Encode()
+}
+
+// handleSilentAuthError handles the errors a provider returns to a
+// `prompt=none` authorization request when it can't authenticate the user
+// without interaction. Flows started by the silent endpoint report the error
+// to the parent window, any other flow falls back to an interactive login.
+// It returns false if the error was not handled.
+func (p *OAuthProxy) handleSilentAuthError(rw http.ResponseWriter, req *http.Request, errorCode string) bool {
+	switch errorCode {
+	case "login_required", "interaction_required", "consent_required", "account_selection_required":
+	default:
+		return false
+	}
+
+	nonce, appRedirect, err := p.decodeState(req.Form.Get("state"))
+	if err != nil {
+		return false
+	}
+	csrf, err := cookies.LoadCSRFCookie(req, p.csrfCookieOptions, nonce)
+	if err != nil || !csrf.CheckOAuthState(nonce) {
+		return false
+	}
+	csrf.ClearCookie(rw, req)
+
+	if !p.redirectValidator.IsValidRedirect(appRedirect) {
+		appRedirect = "/"
+	}
+
+	if strings.HasPrefix(appRedirect, p.ProxyPrefix+silentPath+"?") {
+		http.Redirect(rw, req, p.silentResultURL(errorCode), http.StatusFound)
+		return true
+	}
+
+	logger.Printf("Silent authentication failed (%s). Initiating interactive login.", errorCode)
+	loginURL := p.ProxyPrefix + oauthStartPath + "?" + url.Values{"rd": {appRedirect}}.Encode()
+	http.Redirect(rw, req, loginURL, http.StatusFound)
+	return true
+}
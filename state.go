@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultStateLifetime bounds the age of the OAuth state when the CSRF
+// cookie doesn't expire
+const defaultStateLifetime = 15 * time.Minute
+
+var (
+	// ErrInvalidState means the OAuth state was not issued by this proxy or
+	// was tampered with
+	ErrInvalidState = errors.New("invalid OAuth state")
+
+	// ErrExpiredState means the login took longer than the state is valid
+	ErrExpiredState = errors.New("expired OAuth state")
+)
+
+// oauthState is the content of the OAuth state parameter. It is encrypted
+// and authenticated with the cookie secret, so neither the redirect nor the
+// nonce are visible to the provider and it can't be altered on the way.
+type oauthState struct {
+	Nonce      string `json:"n"`
+	Redirect   string `json:"rd"`
+	ProviderID string `json:"p"`
+	IssuedAt   int64  `json:"t"`
+}
+
+// encodeState builds the OAuth state holding the hashed CSRF nonce and the
+// application redirect
+func (p *OAuthProxy) encodeState(nonce, redirect string) (string, error) {
+	packed, err := json.Marshal(oauthState{
+		Nonce:      nonce,
+		Redirect:   redirect,
+		ProviderID: p.provider.Data().ProviderID,
+		IssuedAt:   time.Now().Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error marshalling OAuth state: %v", err)
+	}
+	encrypted, err := p.stateCipher.Encrypt(packed)
+	if err != nil {
+		return "", fmt.Errorf("error encrypting OAuth state: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(encrypted), nil
+}
+
+// decodeState decrypts the reflected OAuth state back into the hashed nonce
+// and original application redirect. States that fail to decode, were issued
+// for another provider or are too old are rejected.
+func (p *OAuthProxy) decodeState(state string) (string, string, error) {
+	encrypted, err := base64.RawURLEncoding.DecodeString(state)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	packed, err := p.stateCipher.Decrypt(encrypted)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	var s oauthState
+	if err := json.Unmarshal(packed, &s); err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+
+	if s.Nonce == "" {
+		return "", "", fmt.Errorf("%w: missing nonce", ErrInvalidState)
+	}
+	if s.ProviderID != p.provider.Data().ProviderID {
+		return "", "", fmt.Errorf("%w: issued for provider %q", ErrInvalidState, s.ProviderID)
+	}
+
+	if !p.withinStateLifetime(s.IssuedAt) {
+		return "", "", ErrExpiredState
+	}
+
+	return s.Nonce, s.Redirect, nil
+}
+
+// stateLifetime is how long a login may take, bounding the OAuth state and
+// anything else kept for the duration of the login
+func (p *OAuthProxy) stateLifetime() time.Duration {
+	if p.CookieOptions.CSRFExpire <= 0 {
+		return defaultStateLifetime
+	}
+	return p.CookieOptions.CSRFExpire
+}
+
+// withinStateLifetime reports whether something issued at the Unix time
+// issuedAt is still valid for the login
+func (p *OAuthProxy) withinStateLifetime(issuedAt int64) bool {
+	issued := time.Unix(issuedAt, 0)
+	return time.Since(issued) <= p.stateLifetime() && time.Until(issued) <= time.Minute
+}
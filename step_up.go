@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"oidc/pkg/apis/options"
+	sessionsapi "oidc/pkg/apis/sessions"
+	"oidc/pkg/cookies"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// If a session created this recently by a step up login still doesn't meet
+// the route's requirements, the provider didn't honour the request and
+// sending the user back again would loop.
+const stepUpLoopWindow = 30 * time.Second
+
+// stepUpRoute is a compiled options.StepUpRoute
+type stepUpRoute struct {
+	path      *regexp.Regexp
+	acrValues []string
+	amr       []string
+	maxAge    time.Duration
+}
+
+func buildStepUpRoutes(opts *options.Options) ([]stepUpRoute, error) {
+	routes := make([]stepUpRoute, 0, len(opts.StepUpRoutes))
+	for _, route := range opts.StepUpRoutes {
+		path, err := regexp.Compile(route.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling step up route path %q: %v", route.Path, err)
+		}
+		routes = append(routes, stepUpRoute{
+			path:      path,
+			acrValues: route.ACRValues,
+			amr:       route.AMR,
+			maxAge:    route.MaxAge,
+		})
+	}
+	return routes, nil
+}
+
+// stepUpRequirement returns the first step up route matching the request
+func (p *OAuthProxy) stepUpRequirement(req *http.Request) *stepUpRoute {
+	for i := range p.stepUpRoutes {
+		if p.stepUpRoutes[i].path.MatchString(req.URL.Path) {
+			return &p.stepUpRoutes[i]
+		}
+	}
+	return nil
+}
+
+// stepUpIfRequired checks the session against the request's step up route.
+// If the session isn't sufficient, the user is sent back to the provider to
+// authenticate again, keeping the original request as the redirect, and
+// false is returned.
+func (p *OAuthProxy) stepUpIfRequired(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) bool {
+	route := p.stepUpRequirement(req)
+	if route == nil {
+		return true
+	}
+	attempted := p.stepUpAttempted(req)
+	reason := route.unmetBy(session)
+	if reason == "" {
+		if attempted {
+			p.setStepUpCookie(rw, req, -time.Hour)
+		}
+		return true
+	}
+
+	if attempted && recentlyAuthenticated(session) {
+		// The provider was just asked for this authentication context and
+		// didn't provide it, asking again would loop
+		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Step up authentication failed: %s", reason)
+		p.setStepUpCookie(rw, req, -time.Hour)
+		writeOAuthError(rw, http.StatusForbidden, "insufficient_user_authentication", reason)
+		return false
+	}
+
+	logger.PrintAuthf(session.Email, req, logger.AuthSuccess, "Step up authentication required: %s", reason)
+	if isAjax(req) {
+		rw.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=\"insufficient_user_authentication\", error_description=%q", reason))
+		writeOAuthError(rw, http.StatusUnauthorized, "insufficient_user_authentication", reason)
+		return false
+	}
+	p.setStepUpCookie(rw, req, stepUpLoopWindow)
+	p.doOAuthStart(rw, req, nil, route.loginParams())
+	return false
+}
+
+// stepUpCookieName marks that the user was sent to the provider to step up
+// their authentication
+func (p *OAuthProxy) stepUpCookieName() string {
+	return p.CookieOptions.Name + "_step_up"
+}
+
+func (p *OAuthProxy) stepUpAttempted(req *http.Request) bool {
+	_, err := req.Cookie(p.stepUpCookieName())
+	return err == nil
+}
+
+// setStepUpCookie sets the step up marker cookie, a negative expiration
+// clears it
+func (p *OAuthProxy) setStepUpCookie(rw http.ResponseWriter, req *http.Request, expiration time.Duration) {
+	http.SetCookie(rw, cookies.MakeCookieFromOptions(req, p.stepUpCookieName(), "1", p.CookieOptions, expiration, time.Now()))
+}
+
+// unmetBy describes why the session doesn't meet the route's requirements.
+// An empty string means the session is sufficient.
+func (r *stepUpRoute) unmetBy(session *sessionsapi.SessionState) string {
+	if len(r.acrValues) > 0 && !contains(r.acrValues, session.ACR) {
+		return fmt.Sprintf("acr %q is not one of %v", session.ACR, r.acrValues)
+	}
+	for _, method := range r.amr {
+		if !contains(session.AMR, method) {
+			return fmt.Sprintf("amr %v does not include %q", session.AMR, method)
+		}
+	}
+	if r.maxAge > 0 {
+		authTime := session.IdPAuthTime
+		if authTime == nil {
+			authTime = session.AuthTime()
+		}
+		if authTime == nil || session.Clock.Since(*authTime) > r.maxAge {
+			return fmt.Sprintf("authentication is older than %s", r.maxAge)
+		}
+	}
+	return ""
+}
+
+// loginParams are the authorization request parameters asking the provider
+// to meet the route's requirements
+func (r *stepUpRoute) loginParams() url.Values {
+	params := url.Values{"prompt": {"login"}}
+	if len(r.acrValues) > 0 {
+		params.Set("acr_values", strings.Join(r.acrValues, " "))
+	}
+	if r.maxAge > 0 {
+		params.Set("max_age", strconv.FormatInt(int64(r.maxAge/time.Second), 10))
+	}
+	return params
+}
+
+// recentlyAuthenticated reports whether the session was created by a login
+// that has only just completed
+func recentlyAuthenticated(session *sessionsapi.SessionState) bool {
+	authTime := session.AuthTime()
+	return authTime != nil && session.Clock.Since(*authTime) < stepUpLoopWindow
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
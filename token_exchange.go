@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"oidc/pkg/apis/options"
+	sessionsapi "oidc/pkg/apis/sessions"
+	"oidc/providers"
+
+	"github.com/oauth2-proxy/oauth2-proxy/v7/pkg/logger"
+)
+
+// Exchanged tokens are replaced this long before they expire so they are
+// still valid when they reach the upstream
+const tokenExchangeExpiryLeeway = 30 * time.Second
+
+// tokenExchangeRoute is a compiled options.TokenExchangeRoute
+type tokenExchangeRoute struct {
+	path    *regexp.Regexp
+	request providers.TokenExchangeRequest
+}
+
+func buildTokenExchangeRoutes(opts *options.Options) ([]tokenExchangeRoute, error) {
+	routes := make([]tokenExchangeRoute, 0, len(opts.TokenExchangeRoutes))
+	for _, route := range opts.TokenExchangeRoutes {
+		path, err := regexp.Compile(route.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling token exchange route path %q: %v", route.Path, err)
+		}
+		routes = append(routes, tokenExchangeRoute{
+			path: path,
+			request: providers.TokenExchangeRequest{
+				Audience: route.Audience,
+				Resource: route.Resource,
+				Scope:    route.Scope,
+			},
+		})
+	}
+	return routes, nil
+}
+
+// exchangedTokens caches exchanged tokens by the session's access token and
+// the requested audience, so each session only exchanges its token once per
+// upstream until the exchanged token expires
+type exchangedTokens struct {
+	mu      sync.Mutex
+	entries map[string]exchangedToken
+}
+
+type exchangedToken struct {
+	accessToken string
+	expires     time.Time
+}
+
+func newExchangedTokens() *exchangedTokens {
+	return &exchangedTokens{entries: make(map[string]exchangedToken)}
+}
+
+func exchangedTokenKey(subjectToken string, r providers.TokenExchangeRequest) string {
+	h := sha256.New()
+	for _, v := range []string{subjectToken, r.Audience, r.Resource, r.Scope} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (e *exchangedTokens) get(key string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entry, ok := e.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expires) {
+		delete(e.entries, key)
+		return "", false
+	}
+	return entry.accessToken, true
+}
+
+func (e *exchangedTokens) put(key, accessToken string, expires time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range e.entries {
+		if now.After(entry.expires) {
+			delete(e.entries, k)
+		}
+	}
+	e.entries[key] = exchangedToken{accessToken: accessToken, expires: expires}
+}
+
+// tokenExchangeRequirement returns the first token exchange route matching
+// the request
+func (p *OAuthProxy) tokenExchangeRequirement(req *http.Request) *tokenExchangeRoute {
+	for i := range p.tokenExchangeRoutes {
+		if p.tokenExchangeRoutes[i].path.MatchString(req.URL.Path) {
+			return &p.tokenExchangeRoutes[i]
+		}
+	}
+	return nil
+}
+
+// addUpstreamAuthorization sets the Authorization header passed upstream to
+// a token exchanged for the request's token exchange route. If the exchange
+// fails an error response has been written and false is returned.
+func (p *OAuthProxy) addUpstreamAuthorization(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) bool {
+	route := p.tokenExchangeRequirement(req)
+	if route == nil {
+		return true
+	}
+
+	key := exchangedTokenKey(session.AccessToken, route.request)
+	accessToken, ok := p.exchangedTokens.get(key)
+	if !ok {
+		token, err := p.provider.ExchangeToken(req.Context(), session.AccessToken, route.request)
+		if err != nil {
+			logger.PrintAuthf(session.Email, req, logger.AuthError, "Error exchanging token for %s: %v", route.path, err)
+			writeOAuthError(rw, http.StatusBadGateway, "server_error", "unable to obtain a token for the upstream")
+			return false
+		}
+
+		expires := token.Expiry
+		if expires.IsZero() && session.ExpiresOn != nil {
+			// Without its own expiry the token can't outlive the session's
+			expires = *session.ExpiresOn
+		}
+		accessToken = token.AccessToken
+		p.exchangedTokens.put(key, accessToken, expires.Add(-tokenExchangeExpiryLeeway))
+	}
+
+	rw.Header().Set("Authorization", "Bearer "+accessToken)
+	return true
+}